@@ -27,10 +27,26 @@ func main() {
 
 	a, err := agent.NewAgentWithOptions(
 		agent.WithAddress(cfg.Address),
+		agent.WithReportMode(cfg.ReportMode),
 		agent.WithPollInterval(cfg.PollInterval),
 		agent.WithReportInterval(cfg.ReportInterval),
 		agent.WithLogger(logger),
 		agent.WithHashKey(cfg.HaskKey),
+		agent.WithLabels(cfg.Labels),
+		agent.WithGRPCAddress(cfg.GRPCAddress),
+		agent.WithCryptoKey(cfg.CryptoKey),
+		agent.WithCompression(cfg.Compress),
+		agent.WithRateLimit(cfg.RateLimit),
+		agent.WithBatchUpdates(cfg.Batch),
+		agent.WithRetry(cfg.RetryAttempts, cfg.RetryBaseDelay, cfg.RetryMaxDelay, cfg.RetryJitter),
+		agent.WithOutbox(cfg.OutboxDir, cfg.OutboxMaxSize, cfg.OutboxMaxAge),
+		agent.WithProcesses(cfg.ProcessPatterns, cfg.ProcessPIDFiles),
+		agent.WithPlugins(cfg.Plugins, cfg.PluginTimeout),
+		agent.WithStatsD(cfg.StatsDAddress),
+		agent.WithPush(cfg.PushAddress),
+		agent.WithDebugAddress(cfg.DebugAddress),
+		agent.WithDisabledCollectors(cfg.DisabledCollectors),
+		agent.WithCollectorTimeout(cfg.CollectorTimeout),
 	)
 
 	if err != nil {
@@ -2,11 +2,17 @@ package main
 
 import (
 	"context"
+	"net"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 
+	"google.golang.org/grpc"
+
+	"github.com/OmAsana/yapraktikum/internal/encrypt"
 	"github.com/OmAsana/yapraktikum/internal/logging"
+	"github.com/OmAsana/yapraktikum/internal/proto"
 	"github.com/OmAsana/yapraktikum/internal/repository"
 	"github.com/OmAsana/yapraktikum/internal/repository/inmemorystore"
 	"github.com/OmAsana/yapraktikum/internal/repository/sql"
@@ -26,6 +32,23 @@ func startHTTPServer(addr string, handler *server.MetricsServer, logger *logging
 	return srv, nil
 }
 
+func startGRPCServer(addr string, handler *server.MetricsServer, logger *logging.Logger) (*grpc.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	grpcServer := server.NewGRPCServer(handler)
+	srv := grpc.NewServer(grpcServer.ServerOptions()...)
+	proto.RegisterMetricsServer(srv, grpcServer)
+	go func() {
+		if err := srv.Serve(listener); err != nil {
+			logger.S().Error("gRPC server shut down with err: ", err)
+		}
+	}()
+	return srv, nil
+}
+
 func main() {
 
 	sigGracefullQuit, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
@@ -33,6 +56,14 @@ func main() {
 
 	logger := logging.NewLogger()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		defer logger.Flush()
+		if err := runMigrate(sigGracefullQuit, os.Args[2:], os.Stdout, logger); err != nil {
+			logger.S().Fatal(err)
+		}
+		return
+	}
+
 	cfg, err := server.InitConfig()
 	if err != nil {
 		logger.S().Panic("Could not init config: %s", err)
@@ -58,9 +89,20 @@ func main() {
 		logger.S().Panic(err)
 	}
 
+	var grpcServer *grpc.Server
+	if cfg.GRPCAddress != "" {
+		grpcServer, err = startGRPCServer(cfg.GRPCAddress, handler, logger)
+		if err != nil {
+			logger.S().Panic(err)
+		}
+	}
+
 	connectionsClosed := make(chan struct{})
 	go func() {
 		<-sigGracefullQuit.Done()
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
 		if err := httpServer.Shutdown(context.Background()); err != nil {
 			logger.S().Errorf("Error on shutdown: %s", err)
 		}
@@ -75,19 +117,43 @@ func main() {
 }
 
 func setupHandler(repo repository.MetricsRepository, cfg *server.Config, logger *logging.Logger) (*server.MetricsServer, error) {
-	handler, err := server.NewMetricsServer(
-		repo,
+	opts := []server.Options{
 		server.WithHashKey(cfg.HashKey),
 		server.WithLogger(logger),
-	)
-	return handler, err
+	}
+
+	if cfg.CryptoKey != "" {
+		key, err := encrypt.LoadPrivateKey(cfg.CryptoKey)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, server.WithPrivateKey(key))
+	}
+
+	if cfg.TrustedSubnet != "" {
+		_, subnet, err := net.ParseCIDR(cfg.TrustedSubnet)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, server.WithTrustedSubnet(subnet))
+	}
+
+	return server.NewMetricsServer(repo, opts...)
 }
 
 func setupRepo(cfg *server.Config, logger *logging.Logger) (repository.MetricsRepository, error) {
 	var repo repository.MetricsRepository
 	var err error
 	if cfg.DatabaseDSN != "" {
-		repo, err = sql.NewRepository(cfg.DatabaseDSN, cfg.Restore, sql.WithLogger(logger))
+		repo, err = sql.NewRepository(
+			cfg.DatabaseDSN,
+			cfg.Restore,
+			sql.WithLogger(logger),
+			sql.WithHistory(cfg.HistoryRetention),
+			sql.WithQueryTimeout(cfg.DBQueryTimeout),
+			sql.WithBulkTimeout(cfg.DBBulkTimeout),
+			sql.WithIdempotencyWindow(cfg.IdempotencyWindow),
+		)
 
 	} else {
 		repo, err = inmemorystore.NewInMemoryRepo(
@@ -95,6 +161,8 @@ func setupRepo(cfg *server.Config, logger *logging.Logger) (repository.MetricsRe
 			inmemorystore.WithStoreFile(cfg.StoreFile),
 			inmemorystore.WithStoreInterval(cfg.StoreInterval),
 			inmemorystore.WithLogger(logger),
+			inmemorystore.WithHistory(cfg.HistoryRetention, cfg.HistoryCapacity),
+			inmemorystore.WithIdempotencyWindow(cfg.IdempotencyWindow),
 		)
 	}
 	return repo, err
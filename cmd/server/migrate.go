@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/OmAsana/yapraktikum/internal/logging"
+	"github.com/OmAsana/yapraktikum/internal/repository/sql"
+	"github.com/OmAsana/yapraktikum/internal/server"
+)
+
+const migrateUsage = `Usage: %s migrate [-d dsn] up | down [steps] | status
+
+  up      apply pending migrations
+  down    revert the last steps migrations, 1 by default
+  status  list migrations and when they were applied
+
+`
+
+// runMigrate handles the migrate subcommand. Like the server, it reads the
+// database from -d or DATABASE_DSN, the latter wins.
+func runMigrate(ctx context.Context, args []string, out io.Writer, logger *logging.Logger) error {
+	command := flag.NewFlagSet("migrate", flag.ExitOnError)
+	command.Usage = func() {
+		fmt.Fprintf(command.Output(), migrateUsage, os.Args[0])
+		command.PrintDefaults()
+	}
+	dsn := command.String("d", server.DefaultDatabaseDSN, "Postgre database connection string")
+	if err := command.Parse(args); err != nil {
+		return err
+	}
+	if v, ok := os.LookupEnv("DATABASE_DSN"); ok {
+		*dsn = v
+	}
+	if *dsn == "" {
+		return fmt.Errorf("database connection string is required")
+	}
+
+	migrator, err := sql.NewMigrator(*dsn, logger)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	switch command.Arg(0) {
+	case "up":
+		count, err := migrator.Up(ctx)
+		fmt.Fprintf(out, "Applied %d migrations\n", count)
+		return err
+	case "down":
+		steps := 1
+		if command.NArg() > 1 {
+			steps, err = strconv.Atoi(command.Arg(1))
+			if err != nil || steps < 1 {
+				return fmt.Errorf("steps must be a positive number, got %q", command.Arg(1))
+			}
+		}
+		count, err := migrator.Down(ctx, steps)
+		fmt.Fprintf(out, "Reverted %d migrations\n", count)
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return w.Flush()
+	default:
+		command.Usage()
+		return fmt.Errorf("unknown migrate command %q", command.Arg(0))
+	}
+}
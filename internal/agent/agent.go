@@ -2,19 +2,29 @@ package agent
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/OmAsana/yapraktikum/internal/encrypt"
 	"github.com/OmAsana/yapraktikum/internal/handlers"
+	"github.com/OmAsana/yapraktikum/internal/labels"
 	"github.com/OmAsana/yapraktikum/internal/logging"
 	"github.com/OmAsana/yapraktikum/internal/metrics"
+	"github.com/OmAsana/yapraktikum/internal/proto"
 )
 
 var defaultBaseURL *url.URL
@@ -22,31 +32,64 @@ var defaultBaseURL *url.URL
 type config struct {
 	PollInterval   time.Duration
 	ReportInterval time.Duration
-	BaseURL        *url.URL
+	BaseURLs       []*url.URL
+	ReportMode     string
 	HashKey        string
+	Labels         labels.Labels
+	GRPCAddress    string
+	PublicKey      *rsa.PublicKey
+	Compress       bool
+	Retry          retryPolicy
+	RateLimit      int
+	Batch          bool
+	OutboxDir      string
+	OutboxMaxSize  int64
+	OutboxMaxAge   time.Duration
 }
 type Agent struct {
 	registry   *metrics.Registry
 	cfg        config
 	httpClient *http.Client
+	grpcClient proto.MetricsClient
+	statsd     *statsdListener
+	push       *pushListener
+	debug      *debugServer
+	telemetry  *telemetry
 	log        *logging.Logger
+
+	destinations []*destination
 }
 
 func NewDefaultAgent() *Agent {
 	defaultBaseURL, _ = url.Parse(fmt.Sprintf("http://%s", DefaultAddress))
-	return &Agent{registry: metrics.NewRegistry(),
+	agent := &Agent{registry: metrics.NewRegistry(),
 		httpClient: &http.Client{},
 		log:        logging.NewNoop(),
 		cfg: config{
 			PollInterval:   DefaultPollInterval,
 			ReportInterval: DefaultReportInterval,
-			BaseURL:        defaultBaseURL,
+			BaseURLs:       []*url.URL{defaultBaseURL},
+			ReportMode:     DefaultReportMode,
+			RateLimit:      DefaultRateLimit,
+			Batch:          DefaultBatch,
+			Retry: retryPolicy{
+				Attempts:  DefaultRetryAttempts,
+				BaseDelay: DefaultRetryBaseDelay,
+				MaxDelay:  DefaultRetryMaxDelay,
+				Jitter:    DefaultRetryJitter,
+			},
 		}}
+	agent.telemetry = newTelemetry(agent.queuedBytes)
+	agent.registry.AddCollector(agent.telemetry)
+	// Without an outbox there is nothing to fail.
+	agent.destinations, _ = newDestinations(agent)
+	return agent
 }
 
 func NewAgentWithBaseURL(baseURL *url.URL) *Agent {
 	agent := NewDefaultAgent()
-	agent.cfg.BaseURL = baseURL
+	agent.cfg.BaseURLs = []*url.URL{baseURL}
+	agent.destinations, _ = newDestinations(agent)
 	return agent
 }
 
@@ -60,15 +103,40 @@ func NewAgentWithOptions(opts ...Option) (*Agent, error) {
 		}
 	}
 
+	if agent.cfg.PublicKey != nil && agent.grpcClient != nil {
+		return nil, fmt.Errorf("encryption is not supported over gRPC")
+	}
+	if agent.cfg.PublicKey != nil && !agent.cfg.Batch {
+		return nil, fmt.Errorf("encryption requires batch updates")
+	}
+	// Every destination would send to the same gRPC address.
+	if agent.grpcClient != nil && len(agent.cfg.BaseURLs) > 1 {
+		return nil, fmt.Errorf("gRPC reports to a single address, %s needs HTTP", agent.cfg.ReportMode)
+	}
+
+	destinations, err := newDestinations(agent)
+	if err != nil {
+		return nil, err
+	}
+	agent.destinations = destinations
 	return agent, nil
 }
 
 func (a *Agent) logState() {
+	addresses := make([]string, len(a.cfg.BaseURLs))
+	for i, u := range a.cfg.BaseURLs {
+		addresses[i] = u.String()
+	}
+	address := strings.Join(addresses, ",")
+	if a.grpcClient != nil {
+		address = "grpc://" + a.cfg.GRPCAddress
+	}
 	a.log.S().Infof(
-		"Agent started. PollInterval: %.2fs, ReportInterval: %.2fs, Report to address: %q",
+		"Agent started. PollInterval: %.2fs, ReportInterval: %.2fs, Report to address: %q, mode: %s",
 		a.cfg.PollInterval.Seconds(),
 		a.cfg.ReportInterval.Seconds(),
-		a.cfg.BaseURL.String(),
+		address,
+		a.cfg.ReportMode,
 	)
 }
 
@@ -80,6 +148,30 @@ func (a *Agent) Server(ctx context.Context) {
 
 	wg := sync.WaitGroup{}
 
+	if a.statsd != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.statsd.serve(ctx)
+		}()
+	}
+
+	if a.push != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.push.serve(ctx)
+		}()
+	}
+
+	if a.debug != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.debug.serve(ctx)
+		}()
+	}
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -87,6 +179,7 @@ func (a *Agent) Server(ctx context.Context) {
 			select {
 			case <-pollTicker.C:
 				err := a.registry.Collect(ctx)
+				a.telemetry.collected(err)
 				if err != nil {
 					a.log.S().Error(err)
 				}
@@ -96,13 +189,22 @@ func (a *Agent) Server(ctx context.Context) {
 		}
 	}()
 
+	jobs := make(chan reportJob)
+	for i := 0; i < a.cfg.RateLimit; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.reportWorker(ctx, jobs)
+		}()
+	}
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		for {
 			select {
 			case <-reportTicker.C:
-				a.reportAPIv3()
+				a.enqueueReport(ctx, jobs)
 			case <-ctx.Done():
 				return
 			}
@@ -111,42 +213,102 @@ func (a *Agent) Server(ctx context.Context) {
 	wg.Wait()
 }
 
+// sendRequest sends req, retrying transient failures according to the retry
+// policy until the request context is done. The request body is replayed
+// with GetBody, requests without it are sent once.
 func (a *Agent) sendRequest(req *http.Request) error {
+	if ip, err := outboundIP(req.URL.Host); err != nil {
+		a.log.S().Debugf("Could not detect outbound IP: %s", err)
+	} else {
+		req.Header.Set("X-Real-IP", ip.String())
+	}
+
+	policy := a.cfg.Retry
+	for attempt := 1; ; attempt++ {
+		err := a.doRequest(req)
+		if err == nil {
+			return nil
+		}
+		if attempt >= policy.Attempts || !retryable(err) || (req.Body != nil && req.GetBody == nil) {
+			return err
+		}
+
+		delay := policy.delay(attempt)
+		var respErr *responseError
+		if errors.As(err, &respErr) && respErr.RetryAfter > 0 {
+			delay = respErr.RetryAfter
+			if delay > policy.MaxDelay {
+				delay = policy.MaxDelay
+			}
+		}
+		a.log.S().Debugf("Attempt %d failed, retrying in %s: %s", attempt, delay, err)
+		if sleepErr := sleep(req.Context(), delay); sleepErr != nil {
+			return fmt.Errorf("%w, last error: %s", sleepErr, err)
+		}
 
+		req = req.Clone(req.Context())
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (a *Agent) doRequest(req *http.Request) error {
+	start := time.Now()
 	resp, err := a.httpClient.Do(req)
+	a.telemetry.observeLatency(time.Since(start))
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, err := io.ReadAll(resp.Body)
 		if err != nil {
-			return errors.New(err.Error())
+			return err
 		}
-		return fmt.Errorf("something went wrong: %s", string(bodyBytes))
+		retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		return &responseError{StatusCode: resp.StatusCode, Body: string(bodyBytes), RetryAfter: retryAfter}
 	}
 
-	defer resp.Body.Close()
-	return nil
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
 
+// responseError is returned by sendRequest when the server does not reply
+// with 200 OK.
+type responseError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
 }
 
-func (a *Agent) plainTextRequest(path string) (*http.Request, error) {
-	rel := &url.URL{Path: path}
-	u := a.cfg.BaseURL.ResolveReference(rel)
-	req, err := http.NewRequest(http.MethodPost, u.String(), nil)
+func (e *responseError) Error() string {
+	return fmt.Sprintf("something went wrong: %d %s", e.StatusCode, e.Body)
+}
+
+// outboundIP returns the local address used to reach host. Dialing UDP only
+// picks a route, nothing is sent.
+func outboundIP(host string) (net.IP, error) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "80")
+	}
+
+	conn, err := net.Dial("udp", host)
 	if err != nil {
 		return nil, err
 	}
+	defer conn.Close()
 
-	req.Header.Set("Content-Type", "text/plain")
-	return req, nil
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
 }
 
-func (a *Agent) jsonRequest(path string, body io.Reader) (*http.Request, error) {
+func (a *Agent) jsonRequest(ctx context.Context, baseURL *url.URL, path string, body io.Reader) (*http.Request, error) {
 	rel := &url.URL{Path: path}
-	u := a.cfg.BaseURL.ResolveReference(rel)
-	req, err := http.NewRequest(http.MethodPost, u.String(), body)
+	u := baseURL.ResolveReference(rel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), body)
 	if err != nil {
 		return nil, err
 	}
@@ -155,160 +317,332 @@ func (a *Agent) jsonRequest(path string, body io.Reader) (*http.Request, error)
 	return req, nil
 }
 
-func (a *Agent) updateGaugeURL(gauge metrics.Gauge) string {
-	return fmt.Sprintf("/update/gauge/%s/%f", gauge.Name, gauge.Value)
+// newIdempotencyKey returns a random key identifying a batch.
+func newIdempotencyKey() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
 }
 
-func (a *Agent) updateCounterURL(counter metrics.Counter) string {
-	return fmt.Sprintf("/update/counter/%s/%d", counter.Name, counter.Value)
+func setIdempotencyKey(req *http.Request, key string) {
+	if key != "" {
+		req.Header.Set(handlers.HeaderIdempotencyKey, key)
+	}
 }
 
-func (a *Agent) report() {
-	for _, gauge := range a.registry.Gauges {
-		req, err := a.plainTextRequest(a.updateGaugeURL(gauge))
-		if err != nil {
-			a.log.S().Error(err)
+// reportJob is a batch for a destination along with the snapshot it was
+// built from. The job owns deltas of the snapshot and gives them back to the
+// destination if it cannot deliver them. Every attempt to deliver the batch
+// carries the same idempotency key, so the server applies it once.
+type reportJob struct {
+	destination *destination
+	snapshot    metrics.Snapshot
+	batch       []*handlers.Metrics
+	key         string
+}
+
+// enqueueReport hands jobs to the workers. It blocks while all of them are
+// busy, so a slow server delays reports instead of piling them up, and
+// deltas keep accumulating in the registry meanwhile.
+func (a *Agent) enqueueReport(ctx context.Context, jobs chan<- reportJob) {
+	pending := a.prepareJobs()
+	for i, job := range pending {
+		select {
+		case jobs <- job:
+		case <-ctx.Done():
+			for _, job := range pending[i:] {
+				job.destination.restore(job.snapshot)
+			}
+			return
 		}
-		_ = a.sendRequest(req)
 	}
+}
 
-	for _, counter := range a.registry.Counters {
-		req, err := a.plainTextRequest(a.updateCounterURL(counter))
-		if err != nil {
-			a.log.S().Error(err)
+func (a *Agent) reportWorker(ctx context.Context, jobs <-chan reportJob) {
+	for {
+		select {
+		case job := <-jobs:
+			a.runJob(ctx, job)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// prepareJobs moves everything collected since the last report out of the
+// registry and adds it to what every destination has pending: one job per
+// destination, or one per metric if batch updates are disabled.
+func (a *Agent) prepareJobs() []reportJob {
+	snapshot := a.registry.Export()
+	a.registry.Commit(snapshot)
+
+	var jobs []reportJob
+	for _, d := range a.destinations {
+		taken := d.take(snapshot)
+		snapshots := []metrics.Snapshot{taken}
+		if !a.cfg.Batch {
+			snapshots = splitSnapshot(taken)
 		}
-		_ = a.sendRequest(req)
 
+		for _, s := range snapshots {
+			batch, err := a.prepareBatch(s)
+			if err != nil {
+				a.log.S().Error("Error preparing batch: ", err)
+				d.restore(s)
+				continue
+			}
+			key, err := newIdempotencyKey()
+			if err != nil {
+				a.log.S().Error("Error generating idempotency key: ", err)
+				d.restore(s)
+				continue
+			}
+			jobs = append(jobs, reportJob{destination: d, snapshot: s, batch: batch, key: key})
+		}
 	}
+	return jobs
+}
 
-	req, err := a.plainTextRequest(a.updateCounterURL(a.registry.PollCounter))
-	if err != nil {
-		a.log.S().Error(err)
+func (a *Agent) runJob(ctx context.Context, job reportJob) {
+	if err := a.deliver(ctx, job.destination, job.batch, job.key); err != nil {
+		a.log.S().Error("Could not complete request: ", err)
+		job.destination.restore(job.snapshot)
 	}
-	_ = a.sendRequest(req)
 }
 
-func (a Agent) reportAPIv3() {
+// splitSnapshot returns a snapshot per metric.
+func splitSnapshot(s metrics.Snapshot) []metrics.Snapshot {
+	var result []metrics.Snapshot
+	for _, g := range s.Gauges {
+		result = append(result, metrics.Snapshot{Gauges: []metrics.Gauge{g}})
+	}
+	for _, c := range s.Counters {
+		result = append(result, metrics.Snapshot{Counters: []metrics.Counter{c}})
+	}
+	for _, h := range s.Histograms {
+		result = append(result, metrics.Snapshot{Histograms: []metrics.Histogram{h}})
+	}
+	return result
+}
 
-	var metrics []*handlers.Metrics
+// deliver sends batch to d after everything queued in its outbox. A batch
+// that cannot be sent is queued, and from then on the outbox owns its deltas.
+func (a *Agent) deliver(ctx context.Context, d *destination, batch []*handlers.Metrics, key string) error {
+	if d.outbox == nil {
+		return a.send(ctx, d, batch, key)
+	}
 
-	gauges, counters := a.registry.Export()
+	err := d.outbox.replay(func(queued []*handlers.Metrics, queuedKey string) error {
+		return a.send(ctx, d, queued, queuedKey)
+	})
+	if err == nil {
+		if err = a.send(ctx, d, batch, key); err != nil {
+			d.outbox.failed()
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	if !errors.Is(err, errOutboxBackoff) {
+		a.log.S().Warn("Could not complete request, batch queued: ", err)
+	}
+	if err := d.outbox.push(batch, key); err != nil {
+		return fmt.Errorf("could not queue batch: %w", err)
+	}
+	return nil
+}
+
+func (a *Agent) send(ctx context.Context, d *destination, batch []*handlers.Metrics, key string) error {
+	if a.grpcClient != nil {
+		start := time.Now()
+		err := a.reportGRPC(ctx, batch, key)
+		a.telemetry.observeLatency(time.Since(start))
+		a.telemetry.reported(a.cfg.GRPCAddress, len(batch), err)
+		return err
+	}
+
+	err := a.reportHTTP(ctx, d, batch, key)
+	a.telemetry.reported(d.activeURL().Host, len(batch), err)
+	return err
+}
+
+// queuedBytes returns the size of all outboxes.
+func (a *Agent) queuedBytes() int64 {
+	var total int64
+	for _, d := range a.destinations {
+		if d.outbox == nil {
+			continue
+		}
+		size, err := d.outbox.size()
+		if err != nil {
+			a.log.S().Debug("Could not read outbox size: ", err)
+			continue
+		}
+		total += size
+	}
+	return total
+}
+
+// prepareBatch converts a registry snapshot into a single signed batch.
+func (a Agent) prepareBatch(snapshot metrics.Snapshot) ([]*handlers.Metrics, error) {
+	var batch []*handlers.Metrics
 
-	for _, gauge := range gauges {
+	for _, gauge := range snapshot.Gauges {
+		value := gauge.Value
 		metric := &handlers.Metrics{
-			ID:    gauge.Name,
-			MType: "gauge",
-			Value: &gauge.Value,
+			ID:     gauge.Name,
+			MType:  "gauge",
+			Value:  &value,
+			Labels: a.cfg.Labels.Merge(gauge.Labels),
 		}
-		metrics = append(metrics, metric)
+		batch = append(batch, metric)
 	}
 
-	for _, counter := range counters {
+	for _, counter := range snapshot.Counters {
+		delta := counter.Value
 		metric := &handlers.Metrics{
-			ID:    counter.Name,
-			MType: "counter",
-			Delta: &counter.Value,
+			ID:     counter.Name,
+			MType:  "counter",
+			Delta:  &delta,
+			Labels: a.cfg.Labels.Merge(counter.Labels),
 		}
-		metrics = append(metrics, metric)
+		batch = append(batch, metric)
 
 	}
 
-	metrics = append(metrics, &handlers.Metrics{
-		ID:    a.registry.PollCounter.Name,
-		MType: "counter",
-		Delta: &a.registry.PollCounter.Value},
-	)
+	for _, h := range snapshot.Histograms {
+		h.Labels = a.cfg.Labels.Merge(h.Labels)
+		metric := metrics.HistogramToHandlerScheme(h)
+		batch = append(batch, &metric)
+	}
 
 	if a.cfg.HashKey != "" {
-		for _, m := range metrics {
+		for _, m := range batch {
 			err := m.HashMetric(a.cfg.HashKey)
 			if err != nil {
-				a.log.S().Error("Error hashing metric: ", err)
-				return
+				return nil, fmt.Errorf("error hashing metric: %w", err)
 			}
 
 		}
 	}
+	return batch, nil
+}
 
-	var buf bytes.Buffer
-	err := json.NewEncoder(&buf).Encode(metrics)
-	if err != nil {
-		a.log.S().Error("Error encoding metrics: ", err)
-		return
+// reportHTTP sends batch to the active address of d. If it is down, the
+// batch goes to the first address answering /ping, which becomes active.
+func (a *Agent) reportHTTP(ctx context.Context, d *destination, batch []*handlers.Metrics, key string) error {
+	active := d.activeURL()
+	err := a.postBatch(ctx, d, active, batch, key)
+	if err == nil || len(d.urls) < 2 || !retryable(err) {
+		return err
 	}
 
-	req, err := a.jsonRequest("/updates/", &buf)
-	if err != nil {
-		a.log.S().Error("Error preparing request: ", err)
-		return
+	next, ok := a.failover(ctx, d)
+	if !ok {
+		return err
 	}
-	err = a.sendRequest(req)
-	if err != nil {
-		a.log.S().Error("Could not complete request: ", err)
+	if next != active {
+		a.log.S().Warnf("Switching from %s to %s: %s", active, next, err)
 	}
+	return a.postBatch(ctx, d, next, batch, key)
 }
 
-func (a Agent) reportAPIv2() {
+func (a *Agent) postBatch(ctx context.Context, d *destination, baseURL *url.URL, batch []*handlers.Metrics, key string) error {
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(batch)
+	if err != nil {
+		return fmt.Errorf("error encoding metrics: %w", err)
+	}
 
-	var sendRequest = func(metricStream <-chan handlers.Metrics) {
+	if !a.cfg.Batch {
+		return a.reportEach(ctx, baseURL, batch, key)
+	}
 
-		for {
-			m, open := <-metricStream
-			if !open {
-				return
-			}
-			if a.cfg.HashKey != "" {
-				err := m.HashMetric(a.cfg.HashKey)
-				if err != nil {
-					a.log.S().Error(err)
-					continue
-				}
-			}
+	if a.cfg.Compress && atomic.LoadInt32(&d.gzipRejected) == 0 {
+		err = a.postUpdates(ctx, baseURL, buf.Bytes(), true, key)
 
-			var buf bytes.Buffer
-			err := json.NewEncoder(&buf).Encode(m)
-			if err != nil {
-				a.log.S().Error(err)
-				continue
-			}
-			req, err := a.jsonRequest("/update/", &buf)
-			if err != nil {
-				a.log.S().Error(err)
-				continue
-			}
-			_ = a.sendRequest(req)
+		// Servers without request decompression answer 400 to a gzip body
+		// they fail to decode, others 415. Any other error is about the
+		// batch itself, sending it uncompressed would not help.
+		var respErr *responseError
+		if !errors.As(err, &respErr) ||
+			(respErr.StatusCode != http.StatusBadRequest && respErr.StatusCode != http.StatusUnsupportedMediaType) {
+			return err
+		}
 
+		// The batch may be bad too, gzip is only given up if the server
+		// accepts it uncompressed.
+		if plainErr := a.postUpdates(ctx, baseURL, buf.Bytes(), false, key); plainErr != nil {
+			return plainErr
 		}
+		a.log.S().Warn("Server rejected gzip payload, falling back to plain JSON: ", err)
+		atomic.StoreInt32(&d.gzipRejected, 1)
+		return nil
 	}
+	return a.postUpdates(ctx, baseURL, buf.Bytes(), false, key)
+}
 
-	metricStream := make(chan handlers.Metrics)
-	go sendRequest(metricStream)
-
-	for _, gauge := range a.registry.Gauges {
-		metric := handlers.Metrics{
-			ID:    gauge.Name,
-			MType: "gauge",
-			Value: &gauge.Value,
+// reportEach sends metrics one by one to /update/. Batches replayed from the
+// outbox are sent this way too if batch updates are disabled, then every
+// metric gets a key of its own derived from the batch key.
+func (a *Agent) reportEach(ctx context.Context, baseURL *url.URL, batch []*handlers.Metrics, key string) error {
+	for i, m := range batch {
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(m); err != nil {
+			return fmt.Errorf("error encoding metric: %w", err)
 		}
-		metricStream <- metric
-
-	}
-	for _, counter := range a.registry.Counters {
-		metric := handlers.Metrics{
-			ID:    counter.Name,
-			MType: "counter",
-			Delta: &counter.Value,
+		req, err := a.jsonRequest(ctx, baseURL, "/update/", bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			return fmt.Errorf("error preparing request: %w", err)
+		}
+		metricKey := key
+		if key != "" && len(batch) > 1 {
+			metricKey = fmt.Sprintf("%s-%d", key, i)
+		}
+		setIdempotencyKey(req, metricKey)
+		if err := a.sendRequest(req); err != nil {
+			return err
 		}
-		metricStream <- metric
-
 	}
+	return nil
+}
 
-	metricStream <- handlers.Metrics{
-		ID:    a.registry.PollCounter.Name,
-		MType: "counter",
-		Delta: &a.registry.PollCounter.Value,
+// postUpdates sends a JSON encoded batch. The payload is compressed before it
+// is encrypted, Content-Encoding describes the payload inside the envelope.
+func (a *Agent) postUpdates(ctx context.Context, baseURL *url.URL, payload []byte, compress bool, key string) error {
+	if compress {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(payload); err != nil {
+			return fmt.Errorf("error compressing metrics: %w", err)
+		}
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("error compressing metrics: %w", err)
+		}
+		payload = buf.Bytes()
 	}
 
-	close(metricStream)
+	if a.cfg.PublicKey != nil {
+		encrypted, err := encrypt.EncryptHybrid(a.cfg.PublicKey, payload)
+		if err != nil {
+			return fmt.Errorf("error encrypting metrics: %w", err)
+		}
+		payload = encrypted
+	}
 
+	req, err := a.jsonRequest(ctx, baseURL, "/updates/", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error preparing request: %w", err)
+	}
+	if compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if a.cfg.PublicKey != nil {
+		req.Header.Set(encrypt.HeaderEncryption, encrypt.HybridScheme)
+	}
+	setIdempotencyKey(req, key)
+	return a.sendRequest(req)
 }
@@ -3,19 +3,32 @@ package agent
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
 
 	"github.com/OmAsana/yapraktikum/internal/handlers"
+	"github.com/OmAsana/yapraktikum/internal/labels"
 	"github.com/OmAsana/yapraktikum/internal/metrics"
 	"github.com/OmAsana/yapraktikum/internal/pkg"
+	"github.com/OmAsana/yapraktikum/internal/proto"
 	"github.com/OmAsana/yapraktikum/internal/repository"
 	"github.com/OmAsana/yapraktikum/internal/repository/inmemorystore"
 	"github.com/OmAsana/yapraktikum/internal/server"
@@ -33,6 +46,14 @@ func SetupRepo(t *testing.T) repository.MetricsRepository {
 	return repo
 }
 
+// runReports delivers everything collected since the last report the way
+// report workers do, and waits for delivery.
+func runReports(ctx context.Context, agent *Agent) {
+	for _, job := range agent.prepareJobs() {
+		agent.runJob(ctx, job)
+	}
+}
+
 func TestNewAgent(t *testing.T) {
 	handler, err := server.NewMetricsServer(SetupRepo(t))
 	assert.NoError(t, err)
@@ -42,40 +63,6 @@ func TestNewAgent(t *testing.T) {
 	agent.httpClient = metricServer.Client()
 	defer metricServer.Close()
 
-	t.Run("api v1", func(t *testing.T) {
-		t.Run("Add gauge", func(t *testing.T) {
-			testGauge := metrics.Gauge{
-				Name:  "Test",
-				Value: 1.2,
-			}
-			req, err := agent.plainTextRequest(agent.updateGaugeURL(testGauge))
-			require.NoError(t, err)
-
-			err = agent.sendRequest(req)
-			require.NoError(t, err)
-		})
-
-		t.Run("Add counter", func(t *testing.T) {
-			testCounter := metrics.Counter{
-				Name:  "testCounter",
-				Value: 1,
-			}
-			req, err := agent.plainTextRequest(agent.updateCounterURL(testCounter))
-			require.NoError(t, err)
-
-			err = agent.sendRequest(req)
-			require.NoError(t, err)
-		})
-
-		t.Run("Add wrong metric", func(t *testing.T) {
-
-			req, err := agent.plainTextRequest("some/random/val")
-			require.NoError(t, err)
-			err = agent.sendRequest(req)
-			require.Error(t, err)
-		})
-
-	})
 	t.Run("JSON api", func(t *testing.T) {
 		var prepJSONRequest = func(t *testing.T, metric handlers.Metrics) (*http.Request, error) {
 			t.Helper()
@@ -84,7 +71,7 @@ func TestNewAgent(t *testing.T) {
 			if err != nil {
 				return nil, err
 			}
-			return agent.jsonRequest("/update/", &buf)
+			return agent.jsonRequest(context.Background(), baseURL, "/update/", &buf)
 
 		}
 		t.Run("Add gauge", func(t *testing.T) {
@@ -138,7 +125,7 @@ func TestNewAgentWithOptions(t *testing.T) {
 
 		require.NoError(t, err)
 
-		assert.Equal(t, agent.cfg.BaseURL.String(), "http://"+newAddress)
+		assert.Equal(t, agent.cfg.BaseURLs[0].String(), "http://"+newAddress)
 		assert.Equal(t, agent.cfg.PollInterval, func() interface{} {
 			t, _ := time.ParseDuration(newPollInterval)
 			return t
@@ -149,3 +136,477 @@ func TestNewAgentWithOptions(t *testing.T) {
 		}())
 	})
 }
+
+func TestAgent_Labels(t *testing.T) {
+	repo := SetupRepo(t)
+	handler, err := server.NewMetricsServer(repo)
+	require.NoError(t, err)
+	metricServer := httptest.NewServer(handler)
+	defer metricServer.Close()
+
+	agent, err := NewAgentWithOptions(WithAddress(metricServer.URL), WithLabels("host=web-1"))
+	require.NoError(t, err)
+	agent.registry.Gauges = []metrics.Gauge{{Name: "Alloc", Value: 1}}
+
+	runReports(context.Background(), agent)
+
+	got, err := repo.RetrieveGauge(context.Background(), "Alloc", labels.Labels{"host": "web-1"})
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, got.Value)
+
+	_, err = repo.RetrieveGauge(context.Background(), "Alloc", nil)
+	assert.Error(t, err)
+}
+
+func TestAgent_ReportGRPC(t *testing.T) {
+	repo := SetupRepo(t)
+	handler, err := server.NewMetricsServer(repo, server.WithHashKey("key"))
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	proto.RegisterMetricsServer(srv, server.NewGRPCServer(handler))
+	go func() {
+		_ = srv.Serve(listener)
+	}()
+	defer srv.Stop()
+
+	agent, err := NewAgentWithOptions(WithGRPCAddress(listener.Addr().String()), WithHashKey("key"))
+	require.NoError(t, err)
+	agent.registry.Gauges = []metrics.Gauge{{Name: "Alloc", Value: 1}, {Name: "Sys", Value: 2}}
+
+	batch, err := agent.prepareBatch(agent.registry.Export())
+	require.NoError(t, err)
+	require.NoError(t, agent.reportGRPC(context.Background(), batch, ""))
+
+	got, err := repo.RetrieveGauge(context.Background(), "Alloc", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, got.Value)
+	got, err = repo.RetrieveGauge(context.Background(), "Sys", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, got.Value)
+
+	// A retried batch is applied once.
+	batch, err = agent.prepareBatch(metrics.Snapshot{Counters: []metrics.Counter{{Name: "Requests", Value: 5}}})
+	require.NoError(t, err)
+	require.NoError(t, agent.reportGRPC(context.Background(), batch, "batch-1"))
+	require.NoError(t, agent.reportGRPC(context.Background(), batch, "batch-1"))
+	counter, err := repo.RetrieveCounter(context.Background(), "Requests", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), counter.Value)
+}
+
+func TestAgent_ReportHistograms(t *testing.T) {
+	repo := SetupRepo(t)
+	handler, err := server.NewMetricsServer(repo)
+	require.NoError(t, err)
+	metricServer := httptest.NewServer(handler)
+	defer metricServer.Close()
+
+	agent, err := NewAgentWithOptions(WithAddress(metricServer.URL))
+	require.NoError(t, err)
+	h := metrics.NewHistogram("GCPauseNs", []float64{10, 100})
+	h.Observe(5)
+	h.Observe(50)
+	agent.registry.Histograms = []metrics.Histogram{h}
+
+	runReports(context.Background(), agent)
+	agent.registry.Histograms[0].Observe(500)
+	runReports(context.Background(), agent)
+
+	got, err := repo.RetrieveHistogram(context.Background(), "GCPauseNs", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 1, 1}, got.Counts)
+	assert.Equal(t, uint64(3), got.Count)
+	assert.Equal(t, 555.0, got.Sum)
+	assert.Equal(t, uint64(0), agent.registry.Histograms[0].Count)
+}
+
+func TestAgent_CryptoKey(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "public.pem")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+
+	repo := SetupRepo(t)
+	handler, err := server.NewMetricsServer(repo, server.WithPrivateKey(priv))
+	require.NoError(t, err)
+	metricServer := httptest.NewServer(handler)
+	defer metricServer.Close()
+
+	agent, err := NewAgentWithOptions(WithAddress(metricServer.URL), WithCryptoKey(keyPath))
+	require.NoError(t, err)
+	agent.registry.Gauges = []metrics.Gauge{{Name: "Alloc", Value: 1}}
+
+	batch, err := agent.prepareBatch(agent.registry.Export())
+	require.NoError(t, err)
+	require.NoError(t, agent.reportHTTP(context.Background(), agent.destinations[0], batch, ""))
+
+	got, err := repo.RetrieveGauge(context.Background(), "Alloc", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, got.Value)
+
+	_, err = NewAgentWithOptions(WithCryptoKey(filepath.Join(t.TempDir(), "missing.pem")))
+	assert.Error(t, err)
+}
+
+func TestAgent_TrustedSubnet(t *testing.T) {
+	tests := []struct {
+		name    string
+		subnet  string
+		wantErr bool
+	}{
+		{name: "loopback trusted", subnet: "127.0.0.0/8"},
+		{name: "other subnet", subnet: "10.10.0.0/16", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, subnet, err := net.ParseCIDR(tt.subnet)
+			require.NoError(t, err)
+			handler, err := server.NewMetricsServer(SetupRepo(t), server.WithTrustedSubnet(subnet))
+			require.NoError(t, err)
+			metricServer := httptest.NewServer(handler)
+			defer metricServer.Close()
+
+			agent, err := NewAgentWithOptions(WithAddress(metricServer.URL))
+			require.NoError(t, err)
+			agent.registry.Gauges = []metrics.Gauge{{Name: "Alloc", Value: 1}}
+
+			batch, err := agent.prepareBatch(agent.registry.Export())
+			require.NoError(t, err)
+			err = agent.reportHTTP(context.Background(), agent.destinations[0], batch, "")
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestAgent_Compression(t *testing.T) {
+	t.Run("gzip accepted", func(t *testing.T) {
+		repo := SetupRepo(t)
+		handler, err := server.NewMetricsServer(repo)
+		require.NoError(t, err)
+		metricServer := httptest.NewServer(handler)
+		defer metricServer.Close()
+
+		agent, err := NewAgentWithOptions(WithAddress(metricServer.URL), WithCompression(true))
+		require.NoError(t, err)
+		agent.registry.Gauges = []metrics.Gauge{{Name: "Alloc", Value: 1}}
+
+		batch, err := agent.prepareBatch(agent.registry.Export())
+		require.NoError(t, err)
+		require.NoError(t, agent.reportHTTP(context.Background(), agent.destinations[0], batch, ""))
+		assert.Zero(t, agent.destinations[0].gzipRejected)
+
+		got, err := repo.RetrieveGauge(context.Background(), "Alloc", nil)
+		require.NoError(t, err)
+		assert.Equal(t, 1.0, got.Value)
+	})
+
+	t.Run("fallback to plain", func(t *testing.T) {
+		var encodings []string
+		metricServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := r.Header.Get("Content-Encoding")
+			encodings = append(encodings, encoding)
+			if encoding != "" {
+				http.Error(w, "unsupported", http.StatusUnsupportedMediaType)
+				return
+			}
+			var batch []handlers.Metrics
+			if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+		}))
+		defer metricServer.Close()
+
+		agent, err := NewAgentWithOptions(WithAddress(metricServer.URL), WithCompression(true))
+		require.NoError(t, err)
+
+		batch, err := agent.prepareBatch(agent.registry.Export())
+		require.NoError(t, err)
+		require.NoError(t, agent.reportHTTP(context.Background(), agent.destinations[0], batch, ""))
+		require.NoError(t, agent.reportHTTP(context.Background(), agent.destinations[0], batch, ""))
+		assert.Equal(t, []string{"gzip", "", ""}, encodings)
+	})
+
+	t.Run("server without decompression", func(t *testing.T) {
+		repo := SetupRepo(t)
+		handler, err := server.NewMetricsServer(repo)
+		require.NoError(t, err)
+		var encodings []string
+		updates := handler.Updates()
+		metricServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encodings = append(encodings, r.Header.Get("Content-Encoding"))
+			updates(w, r)
+		}))
+		defer metricServer.Close()
+
+		agent, err := NewAgentWithOptions(WithAddress(metricServer.URL), WithCompression(true))
+		require.NoError(t, err)
+		agent.registry.Gauges = []metrics.Gauge{{Name: "Alloc", Value: 1}}
+
+		batch, err := agent.prepareBatch(agent.registry.Export())
+		require.NoError(t, err)
+		require.NoError(t, agent.reportHTTP(context.Background(), agent.destinations[0], batch, ""))
+		require.NoError(t, agent.reportHTTP(context.Background(), agent.destinations[0], batch, ""))
+		assert.Equal(t, []string{"gzip", "", ""}, encodings)
+		assert.NotZero(t, agent.destinations[0].gzipRejected)
+
+		got, err := repo.RetrieveGauge(context.Background(), "Alloc", nil)
+		require.NoError(t, err)
+		assert.Equal(t, 1.0, got.Value)
+	})
+
+	t.Run("bad batch keeps gzip", func(t *testing.T) {
+		var encodings []string
+		metricServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encodings = append(encodings, r.Header.Get("Content-Encoding"))
+			http.Error(w, "hash mismatch", http.StatusBadRequest)
+		}))
+		defer metricServer.Close()
+
+		agent, err := NewAgentWithOptions(WithAddress(metricServer.URL), WithCompression(true))
+		require.NoError(t, err)
+
+		batch, err := agent.prepareBatch(agent.registry.Export())
+		require.NoError(t, err)
+		assert.Error(t, agent.reportHTTP(context.Background(), agent.destinations[0], batch, ""))
+		assert.Error(t, agent.reportHTTP(context.Background(), agent.destinations[0], batch, ""))
+		assert.Equal(t, []string{"gzip", "", "gzip", ""}, encodings)
+		assert.Zero(t, agent.destinations[0].gzipRejected)
+	})
+}
+
+func TestAgent_Outbox(t *testing.T) {
+	repo := SetupRepo(t)
+	handler, err := server.NewMetricsServer(repo)
+	require.NoError(t, err)
+
+	down := true
+	var received []float64
+	metricServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var batch []handlers.Metrics
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &batch))
+		received = append(received, *batch[0].Value)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		handler.ServeHTTP(w, r)
+	}))
+	defer metricServer.Close()
+
+	agent, err := NewAgentWithOptions(WithAddress(metricServer.URL), WithRetry(1, 0, 0, 0), WithOutbox(t.TempDir(), 1<<20, time.Hour))
+	require.NoError(t, err)
+	agent.registry.Histograms = []metrics.Histogram{metrics.NewHistogram("GCPauseNs", []float64{10})}
+
+	report := func(alloc float64) {
+		agent.registry.Gauges = []metrics.Gauge{{Name: "Alloc", Value: alloc}}
+		agent.registry.Histograms[0].Observe(1)
+		runReports(context.Background(), agent)
+	}
+
+	report(1)
+	agent.destinations[0].outbox.nextAttempt = time.Time{}
+	report(2)
+	queued, err := agent.destinations[0].outbox.len()
+	require.NoError(t, err)
+	assert.Equal(t, 2, queued)
+
+	down = false
+	agent.destinations[0].outbox.nextAttempt = time.Time{}
+	report(3)
+
+	assert.Equal(t, []float64{1, 2, 3}, received)
+	queued, err = agent.destinations[0].outbox.len()
+	require.NoError(t, err)
+	assert.Zero(t, queued)
+
+	gauge, err := repo.RetrieveGauge(context.Background(), "Alloc", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, gauge.Value)
+	h, err := repo.RetrieveHistogram(context.Background(), "GCPauseNs", nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), h.Count)
+}
+
+func TestAgent_CounterDeltas(t *testing.T) {
+	totals := map[string]int64{}
+	fail := false
+	metricServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var batch []handlers.Metrics
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		for _, m := range batch {
+			if m.MType == "counter" {
+				totals[m.ID] += *m.Delta
+			}
+		}
+	}))
+	defer metricServer.Close()
+
+	agent, err := NewAgentWithOptions(WithAddress(metricServer.URL), WithRetry(1, 0, 0, 0))
+	require.NoError(t, err)
+
+	cycles := []struct {
+		polls int
+		fail  bool
+	}{
+		{polls: 2},
+		{polls: 3},
+		{polls: 1, fail: true},
+		{polls: 2, fail: true},
+		{polls: 4},
+		{polls: 0},
+	}
+	var polls int64
+	for i, c := range cycles {
+		for j := 0; j < c.polls; j++ {
+			require.NoError(t, agent.registry.Collect(context.Background()))
+			agent.registry.AddCounter(metrics.Counter{Name: "Requests", Value: 10})
+		}
+		polls += int64(c.polls)
+		fail = c.fail
+
+		runReports(context.Background(), agent)
+		if c.fail {
+			continue
+		}
+		assert.Equal(t, polls, totals["PollCount"], "cycle %d", i)
+		assert.Equal(t, 10*polls, totals["Requests"], "cycle %d", i)
+	}
+}
+
+func TestAgent_DisabledCollectors(t *testing.T) {
+	agent, err := NewAgentWithOptions(WithDisabledCollectors("cpu, memory"))
+	require.NoError(t, err)
+	require.NoError(t, agent.registry.Collect(context.Background()))
+	for _, g := range agent.registry.Gauges {
+		assert.NotContains(t, []string{"FreeMemory", "TotalMemory", "CPUutilization1"}, g.Name)
+	}
+
+	_, err = NewAgentWithOptions(WithDisabledCollectors("gpu"))
+	assert.Error(t, err)
+}
+
+func TestAgent_RateLimit(t *testing.T) {
+	const limit = 3
+	var inFlight, maxInFlight, requests int32
+	metricServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/update/", r.URL.Path)
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer metricServer.Close()
+
+	agent, err := NewAgentWithOptions(
+		WithAddress(metricServer.URL),
+		WithPollInterval(10*time.Millisecond),
+		WithReportInterval(10*time.Millisecond),
+		WithRateLimit(limit),
+		WithBatchUpdates(false),
+		WithDisabledCollectors("runtime,memory,cpu,disk,diskio,net,load,uptime"),
+	)
+	require.NoError(t, err)
+	agent.registry.AddCollector(metrics.NewGaugeCollector("test", func(ctx context.Context) ([]metrics.Gauge, error) {
+		gauges := make([]metrics.Gauge, 20)
+		for i := range gauges {
+			gauges[i] = metrics.Gauge{Name: fmt.Sprintf("Gauge%d", i), Value: float64(i)}
+		}
+		return gauges, nil
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	agent.Server(ctx)
+
+	assert.Greater(t, atomic.LoadInt32(&requests), int32(limit))
+	assert.Equal(t, int32(limit), atomic.LoadInt32(&maxInFlight))
+}
+
+func TestAgent_EncryptionRequiresBatch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "public.pem")
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+
+	_, err = NewAgentWithOptions(WithCryptoKey(path), WithBatchUpdates(false))
+	assert.Error(t, err)
+
+	_, err = NewAgentWithOptions(WithCryptoKey(path), WithGRPCAddress("127.0.0.1:3200"))
+	assert.Error(t, err)
+}
+
+func TestAgent_GRPCRequiresSingleAddress(t *testing.T) {
+	_, err := NewAgentWithOptions(
+		WithAddress("http://127.0.0.1:8080,http://127.0.0.1:8081"),
+		WithReportMode(ReportModeFanout),
+		WithGRPCAddress("127.0.0.1:3200"),
+	)
+	assert.Error(t, err)
+
+	_, err = NewAgentWithOptions(WithAddress("http://127.0.0.1:8080"), WithGRPCAddress("127.0.0.1:3200"))
+	assert.NoError(t, err)
+}
+
+func TestAgent_IdempotencyKey(t *testing.T) {
+	repo := SetupRepo(t)
+	handler, err := server.NewMetricsServer(repo)
+	require.NoError(t, err)
+
+	var keys []string
+	metricServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(handlers.HeaderIdempotencyKey))
+		if len(keys) == 1 {
+			// The batch is applied, but the response is lost.
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer metricServer.Close()
+
+	agent, err := NewAgentWithOptions(WithAddress(metricServer.URL), WithRetry(3, 0, 0, 0))
+	require.NoError(t, err)
+
+	agent.registry.AddCounter(metrics.Counter{Name: "Requests", Value: 5})
+	runReports(context.Background(), agent)
+	require.Len(t, keys, 2)
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1])
+
+	counter, err := repo.RetrieveCounter(context.Background(), "Requests", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), counter.Value)
+
+	agent.registry.AddCounter(metrics.Counter{Name: "Requests", Value: 5})
+	runReports(context.Background(), agent)
+	require.Len(t, keys, 3)
+	assert.NotEqual(t, keys[0], keys[2])
+
+	counter, err = repo.RetrieveCounter(context.Background(), "Requests", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), counter.Value)
+}
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"github.com/caarlos0/env/v6"
+
+	"github.com/OmAsana/yapraktikum/internal/metrics"
 )
 
 var (
@@ -14,6 +16,30 @@ var (
 	DefaultPollInterval   = 2 * time.Second
 	DefaultHashKey        = ""
 	DefaultLogLevel       = "info"
+	DefaultLabels         = ""
+	DefaultGRPCAddress    = ""
+	DefaultCryptoKey      = ""
+	DefaultCompress       = false
+	DefaultOutboxDir      = ""
+	DefaultOutboxMaxSize  = int64(10 << 20)
+	DefaultOutboxMaxAge   = time.Hour
+
+	DefaultDisabledCollectors = ""
+	DefaultCollectorTimeout   = metrics.DefaultCollectorTimeout
+	DefaultProcessPatterns    = ""
+	DefaultProcessPIDFiles    = ""
+	DefaultPlugins            = ""
+	DefaultPluginTimeout      = metrics.DefaultPluginTimeout
+	DefaultStatsDAddress      = ""
+	DefaultPushAddress        = ""
+	DefaultRetryAttempts      = 3
+	DefaultRetryBaseDelay     = time.Second
+	DefaultRetryMaxDelay      = 5 * time.Second
+	DefaultRetryJitter        = 0.2
+	DefaultRateLimit          = 1
+	DefaultBatch              = true
+	DefaultReportMode         = ReportModeFailover
+	DefaultDebugAddress       = ""
 
 	DefaultConfig = Config{
 		Address:        DefaultAddress,
@@ -21,6 +47,30 @@ var (
 		PollInterval:   DefaultPollInterval,
 		HaskKey:        DefaultHashKey,
 		LogLevel:       DefaultLogLevel,
+		Labels:         DefaultLabels,
+		GRPCAddress:    DefaultGRPCAddress,
+		CryptoKey:      DefaultCryptoKey,
+		Compress:       DefaultCompress,
+		OutboxDir:      DefaultOutboxDir,
+		OutboxMaxSize:  DefaultOutboxMaxSize,
+		OutboxMaxAge:   DefaultOutboxMaxAge,
+
+		DisabledCollectors: DefaultDisabledCollectors,
+		CollectorTimeout:   DefaultCollectorTimeout,
+		ProcessPatterns:    DefaultProcessPatterns,
+		ProcessPIDFiles:    DefaultProcessPIDFiles,
+		Plugins:            DefaultPlugins,
+		PluginTimeout:      DefaultPluginTimeout,
+		StatsDAddress:      DefaultStatsDAddress,
+		PushAddress:        DefaultPushAddress,
+		RetryAttempts:      DefaultRetryAttempts,
+		RetryBaseDelay:     DefaultRetryBaseDelay,
+		RetryMaxDelay:      DefaultRetryMaxDelay,
+		RetryJitter:        DefaultRetryJitter,
+		RateLimit:          DefaultRateLimit,
+		Batch:              DefaultBatch,
+		ReportMode:         DefaultReportMode,
+		DebugAddress:       DefaultDebugAddress,
 	}
 )
 
@@ -30,7 +80,32 @@ type Config struct {
 	PollInterval   time.Duration `env:"POLL_INTERVAL"`
 	HaskKey        string        `env:"KEY"`
 	LogLevel       string        `env:"LOG_LEVEL"`
-	command        *flag.FlagSet
+	Labels         string        `env:"LABELS"`
+	GRPCAddress    string        `env:"GRPC_ADDRESS"`
+	CryptoKey      string        `env:"CRYPTO_KEY"`
+	Compress       bool          `env:"COMPRESS"`
+	OutboxDir      string        `env:"OUTBOX_DIR"`
+	OutboxMaxSize  int64         `env:"OUTBOX_MAX_SIZE"`
+	OutboxMaxAge   time.Duration `env:"OUTBOX_MAX_AGE"`
+
+	DisabledCollectors string        `env:"DISABLED_COLLECTORS"`
+	CollectorTimeout   time.Duration `env:"COLLECTOR_TIMEOUT"`
+	ProcessPatterns    string        `env:"PROCESS_PATTERNS"`
+	ProcessPIDFiles    string        `env:"PROCESS_PID_FILES"`
+	Plugins            string        `env:"PLUGINS"`
+	PluginTimeout      time.Duration `env:"PLUGIN_TIMEOUT"`
+	StatsDAddress      string        `env:"STATSD_ADDRESS"`
+	PushAddress        string        `env:"PUSH_ADDRESS"`
+	RetryAttempts      int           `env:"RETRY_ATTEMPTS"`
+	RetryBaseDelay     time.Duration `env:"RETRY_BASE_DELAY"`
+	RetryMaxDelay      time.Duration `env:"RETRY_MAX_DELAY"`
+	RetryJitter        float64       `env:"RETRY_JITTER"`
+	RateLimit          int           `env:"RATE_LIMIT"`
+	Batch              bool          `env:"BATCH"`
+	ReportMode         string        `env:"REPORT_MODE"`
+	DebugAddress       string        `env:"DEBUG_ADDRESS"`
+
+	command *flag.FlagSet
 }
 
 func InitConfig() (*Config, error) {
@@ -59,9 +134,32 @@ func (c *Config) initCmdFlagsWithArgs(args []string) error {
 	command := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 	reportInterval := command.Duration("r", DefaultReportInterval, "Report interval")
 	pollInterval := command.Duration("p", DefaultPollInterval, "Poll interval")
-	address := command.String("a", DefaultAddress, "Endpoint address")
+	address := command.String("a", DefaultAddress, "Endpoint address. Comma separated addresses are used according to report_mode")
 	hashKey := command.String("k", DefaultHashKey, "Hash key")
 	logLevel := command.String("log_level", DefaultLogLevel, "Log level")
+	lbls := command.String("l", DefaultLabels, "Labels attached to every metric, e.g. host=web-1,env=prod")
+	grpcAddress := command.String("g", DefaultGRPCAddress, "Report to gRPC endpoint address instead of HTTP. Requires a single address in -a and no crypto key")
+	cryptoKey := command.String("crypto-key", DefaultCryptoKey, "Path to PEM public key used to encrypt batch updates")
+	compress := command.Bool("c", DefaultCompress, "Gzip batch updates")
+	outboxDir := command.String("outbox_dir", DefaultOutboxDir, "Queue undelivered batches in this directory. Empty drops them")
+	outboxMaxSize := command.Int64("outbox_max_size", DefaultOutboxMaxSize, "Max outbox size in bytes, oldest batches are dropped first")
+	outboxMaxAge := command.Duration("outbox_max_age", DefaultOutboxMaxAge, "Drop queued batches older than this. 0 keeps them")
+	disabledCollectors := command.String("disable_collectors", DefaultDisabledCollectors, "Comma separated collectors to turn off: runtime, memory, cpu, disk, diskio, net, load, uptime, process, exec, statsd, push, telemetry")
+	collectorTimeout := command.Duration("collector_timeout", DefaultCollectorTimeout, "Max time a single collector may run")
+	processPatterns := command.String("process_patterns", DefaultProcessPatterns, "Comma separated regular expressions of process names to watch")
+	processPIDFiles := command.String("process_pid_files", DefaultProcessPIDFiles, "Comma separated PID files of processes to watch")
+	plugins := command.String("plugins", DefaultPlugins, "Semicolon separated commands printing metrics, run on every poll")
+	pluginTimeout := command.Duration("plugin_timeout", DefaultPluginTimeout, "Max time a single plugin may run. Keep it below collector_timeout")
+	statsdAddress := command.String("statsd", DefaultStatsDAddress, "Listen for StatsD packets on UDP address, e.g. 127.0.0.1:8125")
+	retryAttempts := command.Int("retry_attempts", DefaultRetryAttempts, "Attempts to send a request, including the first one")
+	retryBaseDelay := command.Duration("retry_base_delay", DefaultRetryBaseDelay, "Delay before the first retry, doubled on every next one")
+	retryMaxDelay := command.Duration("retry_max_delay", DefaultRetryMaxDelay, "Max delay between retries")
+	retryJitter := command.Float64("retry_jitter", DefaultRetryJitter, "Fraction from 0 to 1 by which retry delays are randomly shortened")
+	rateLimit := command.Int("rate_limit", DefaultRateLimit, "Max concurrent requests to the server")
+	batch := command.Bool("batch", DefaultBatch, "Send metrics in batches to /updates/. False sends a request per metric to /update/")
+	reportMode := command.String("report_mode", DefaultReportMode, "How to use several addresses: failover sticks to a healthy one, fanout sends to all")
+	debugAddress := command.String("debug", DefaultDebugAddress, "Serve agent telemetry at /debug/telemetry on address, e.g. 127.0.0.1:8082")
+	pushAddress := command.String("push", DefaultPushAddress, "Accept /update/ and /updates/ from local applications on address, e.g. 127.0.0.1:8081")
 
 	if err := command.Parse(args); err != nil {
 		return err
@@ -72,6 +170,29 @@ func (c *Config) initCmdFlagsWithArgs(args []string) error {
 	c.Address = *address
 	c.HaskKey = *hashKey
 	c.LogLevel = *logLevel
+	c.Labels = *lbls
+	c.GRPCAddress = *grpcAddress
+	c.CryptoKey = *cryptoKey
+	c.Compress = *compress
+	c.OutboxDir = *outboxDir
+	c.OutboxMaxSize = *outboxMaxSize
+	c.OutboxMaxAge = *outboxMaxAge
+	c.DisabledCollectors = *disabledCollectors
+	c.CollectorTimeout = *collectorTimeout
+	c.ProcessPatterns = *processPatterns
+	c.ProcessPIDFiles = *processPIDFiles
+	c.Plugins = *plugins
+	c.PluginTimeout = *pluginTimeout
+	c.StatsDAddress = *statsdAddress
+	c.PushAddress = *pushAddress
+	c.RetryAttempts = *retryAttempts
+	c.RetryBaseDelay = *retryBaseDelay
+	c.RetryMaxDelay = *retryMaxDelay
+	c.RetryJitter = *retryJitter
+	c.RateLimit = *rateLimit
+	c.Batch = *batch
+	c.ReportMode = *reportMode
+	c.DebugAddress = *debugAddress
 
 	return nil
 }
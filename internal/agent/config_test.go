@@ -76,6 +76,18 @@ func Test_initCmdFlags(t *testing.T) {
 			PollInterval:   100 * time.Second,
 			HaskKey:        hashKey,
 			LogLevel:       DefaultLogLevel,
+			OutboxMaxSize:  DefaultOutboxMaxSize,
+			OutboxMaxAge:   DefaultOutboxMaxAge,
+
+			CollectorTimeout: DefaultCollectorTimeout,
+			PluginTimeout:    DefaultPluginTimeout,
+			RetryAttempts:    DefaultRetryAttempts,
+			RetryBaseDelay:   DefaultRetryBaseDelay,
+			RetryMaxDelay:    DefaultRetryMaxDelay,
+			RetryJitter:      DefaultRetryJitter,
+			RateLimit:        DefaultRateLimit,
+			Batch:            DefaultBatch,
+			ReportMode:       DefaultReportMode,
 		}
 		assert.EqualValues(t, targetCfg, cfg)
 
@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+)
+
+const (
+	// ReportModeFailover sends to the first healthy address and sticks to it
+	// until it fails.
+	ReportModeFailover = "failover"
+	// ReportModeFanout sends every batch to all addresses.
+	ReportModeFanout = "fanout"
+)
+
+// destination is a stream of batches with its own delta bookkeeping: deltas
+// that were not delivered yet stay in pending and are merged into the next
+// report. In failover mode a destination has several candidate addresses,
+// in fanout mode every address is a destination of its own.
+type destination struct {
+	urls   []*url.URL
+	outbox *outbox
+
+	mu      sync.Mutex
+	active  int
+	pending metrics.Snapshot
+
+	// gzipRejected is set once the server refused the gzip encoding.
+	// Workers read and set it concurrently.
+	gzipRejected int32
+}
+
+// newDestinations groups addresses according to mode. Every destination gets
+// an outbox if outboxDir is set, fanout destinations in a subdirectory each.
+func newDestinations(a *Agent) ([]*destination, error) {
+	var groups [][]*url.URL
+	switch a.cfg.ReportMode {
+	case ReportModeFailover:
+		groups = [][]*url.URL{a.cfg.BaseURLs}
+	case ReportModeFanout:
+		for _, u := range a.cfg.BaseURLs {
+			groups = append(groups, []*url.URL{u})
+		}
+	default:
+		return nil, fmt.Errorf("unknown report mode %q", a.cfg.ReportMode)
+	}
+
+	destinations := make([]*destination, 0, len(groups))
+	for _, urls := range groups {
+		d := &destination{urls: urls}
+		if a.cfg.OutboxDir != "" {
+			dir := a.cfg.OutboxDir
+			if len(groups) > 1 {
+				dir = filepath.Join(dir, strings.ReplaceAll(urls[0].Host, ":", "_"))
+			}
+			o, err := newOutbox(dir, a.cfg.OutboxMaxSize, a.cfg.OutboxMaxAge, a.log)
+			if err != nil {
+				return nil, err
+			}
+			d.outbox = o
+		}
+		destinations = append(destinations, d)
+	}
+	return destinations, nil
+}
+
+func (d *destination) activeURL() *url.URL {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.urls[d.active]
+}
+
+// take returns deltas pending for d together with a new snapshot. From then
+// on the caller owns them and must restore whatever it fails to deliver.
+func (d *destination) take(snapshot metrics.Snapshot) metrics.Snapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	taken := d.pending
+	d.pending = metrics.Snapshot{}
+	taken.Merge(snapshot)
+	return taken
+}
+
+// restore keeps undelivered deltas for the next report. Gauges reported
+// since then are newer, so they win.
+func (d *destination) restore(s metrics.Snapshot) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s.Merge(d.pending)
+	d.pending = s
+}
+
+// failover switches to the first address answering /ping and returns it.
+func (a *Agent) failover(ctx context.Context, d *destination) (*url.URL, bool) {
+	for i, u := range d.urls {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.ResolveReference(&url.URL{Path: "/ping"}).String(), nil)
+		if err != nil {
+			continue
+		}
+		// The server answers 500 when its database check fails, which
+		// servers on the in-memory store always do. They still take
+		// updates, so only other failures rule a server out.
+		var respErr *responseError
+		if err := a.doRequest(req); err != nil &&
+			!(errors.As(err, &respErr) && respErr.StatusCode == http.StatusInternalServerError) {
+			a.log.S().Debugf("%s is unhealthy: %s", u, err)
+			continue
+		}
+
+		d.mu.Lock()
+		d.active = i
+		d.mu.Unlock()
+		return u, true
+	}
+	return nil, false
+}
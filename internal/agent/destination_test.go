@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+	"github.com/OmAsana/yapraktikum/internal/repository"
+	"github.com/OmAsana/yapraktikum/internal/server"
+)
+
+// switchableServer is a metrics server that answers 503 to everything,
+// /ping included, while down.
+type switchableServer struct {
+	*httptest.Server
+	repo    repository.MetricsRepository
+	down    int32
+	updates int32
+}
+
+func newSwitchableServer(t *testing.T) *switchableServer {
+	t.Helper()
+	s := &switchableServer{repo: SetupRepo(t)}
+	handler, err := server.NewMetricsServer(s.repo)
+	require.NoError(t, err)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&s.down) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/updates/" {
+			atomic.AddInt32(&s.updates, 1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *switchableServer) setDown(down bool) {
+	var v int32
+	if down {
+		v = 1
+	}
+	atomic.StoreInt32(&s.down, v)
+}
+
+func (s *switchableServer) counter(t *testing.T, name string) int64 {
+	t.Helper()
+	c, err := s.repo.RetrieveCounter(context.Background(), name, nil)
+	if err != nil {
+		return 0
+	}
+	return c.Value
+}
+
+func reportRequests(t *testing.T, agent *Agent, requests int64) {
+	t.Helper()
+	agent.registry.AddCounter(metrics.Counter{Name: "Requests", Value: requests})
+	runReports(context.Background(), agent)
+}
+
+func TestAgent_Failover(t *testing.T) {
+	primary := newSwitchableServer(t)
+	standby := newSwitchableServer(t)
+
+	agent, err := NewAgentWithOptions(
+		WithAddress(primary.URL+","+standby.URL),
+		WithReportMode(ReportModeFailover),
+		WithRetry(1, 0, 0, 0),
+	)
+	require.NoError(t, err)
+	require.NoError(t, agent.registry.DisableCollectors("runtime", "memory", "cpu", "disk", "diskio", "net", "load", "uptime"))
+
+	reportRequests(t, agent, 1)
+	assert.Equal(t, int64(1), primary.counter(t, "Requests"))
+
+	primary.setDown(true)
+	reportRequests(t, agent, 2)
+	assert.Equal(t, int64(2), standby.counter(t, "Requests"))
+
+	// The agent sticks to the standby once the primary is back.
+	primary.setDown(false)
+	reportRequests(t, agent, 4)
+	assert.Equal(t, int64(6), standby.counter(t, "Requests"))
+	assert.Equal(t, int32(1), primary.updates)
+
+	// Nothing is lost while both are down.
+	standby.setDown(true)
+	primary.setDown(true)
+	reportRequests(t, agent, 8)
+	primary.setDown(false)
+	reportRequests(t, agent, 16)
+	assert.Equal(t, int64(25), primary.counter(t, "Requests"))
+	assert.Equal(t, int64(31), primary.counter(t, "Requests")+standby.counter(t, "Requests"))
+}
+
+func TestAgent_Fanout(t *testing.T) {
+	first := newSwitchableServer(t)
+	second := newSwitchableServer(t)
+
+	agent, err := NewAgentWithOptions(
+		WithAddress(first.URL+","+second.URL),
+		WithReportMode(ReportModeFanout),
+		WithRetry(1, 0, 0, 0),
+	)
+	require.NoError(t, err)
+	require.NoError(t, agent.registry.DisableCollectors("runtime", "memory", "cpu", "disk", "diskio", "net", "load", "uptime"))
+	require.Len(t, agent.destinations, 2)
+
+	reportRequests(t, agent, 1)
+	second.setDown(true)
+	reportRequests(t, agent, 2)
+	reportRequests(t, agent, 4)
+
+	// A target being down does not hold back or duplicate deltas of others.
+	assert.Equal(t, int64(7), first.counter(t, "Requests"))
+	assert.Equal(t, int64(1), second.counter(t, "Requests"))
+
+	second.setDown(false)
+	reportRequests(t, agent, 8)
+	assert.Equal(t, int64(15), first.counter(t, "Requests"))
+	assert.Equal(t, int64(15), second.counter(t, "Requests"))
+}
+
+func TestAgent_FanoutOutbox(t *testing.T) {
+	first := newSwitchableServer(t)
+	second := newSwitchableServer(t)
+
+	dir := t.TempDir()
+	agent, err := NewAgentWithOptions(
+		WithAddress(first.URL+","+second.URL),
+		WithReportMode(ReportModeFanout),
+		WithRetry(1, 0, 0, 0),
+		WithOutbox(dir, 1<<20, 0),
+	)
+	require.NoError(t, err)
+	require.NotEqual(t, agent.destinations[0].outbox.dir, agent.destinations[1].outbox.dir)
+
+	second.setDown(true)
+	reportRequests(t, agent, 1)
+	queued, err := agent.destinations[1].outbox.len()
+	require.NoError(t, err)
+	assert.Equal(t, 1, queued)
+	queued, err = agent.destinations[0].outbox.len()
+	require.NoError(t, err)
+	assert.Zero(t, queued)
+}
+
+func TestWithReportMode(t *testing.T) {
+	_, err := NewAgentWithOptions(WithReportMode("broadcast"))
+	assert.Error(t, err)
+}
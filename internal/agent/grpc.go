@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/OmAsana/yapraktikum/internal/handlers"
+	"github.com/OmAsana/yapraktikum/internal/proto"
+)
+
+func newGRPCClient(address string) (proto.MetricsClient, error) {
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return proto.NewMetricsClient(conn), nil
+}
+
+// reportGRPC streams the batch to the Updates method, which applies it
+// atomically once the stream is closed, and once per idempotency key.
+func (a Agent) reportGRPC(ctx context.Context, batch []*handlers.Metrics, key string) error {
+	if key != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, handlers.HeaderIdempotencyKey, key)
+	}
+
+	if ip, err := outboundIP(a.cfg.GRPCAddress); err != nil {
+		a.log.S().Debugf("Could not detect outbound IP: %s", err)
+	} else {
+		ctx = metadata.AppendToOutgoingContext(ctx, "X-Real-IP", ip.String())
+	}
+
+	stream, err := a.grpcClient.Updates(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range batch {
+		if err := stream.Send(&proto.UpdateRequest{Metric: proto.FromHandler(*m)}); err != nil {
+			return err
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	if resp.GetReceived() != int64(len(batch)) {
+		return fmt.Errorf("server received %d of %d metrics", resp.GetReceived(), len(batch))
+	}
+	return nil
+}
@@ -1,27 +1,53 @@
 package agent
 
 import (
+	"fmt"
+	"net"
 	"net/url"
 	"strings"
 	"time"
 
+	"github.com/OmAsana/yapraktikum/internal/encrypt"
+	"github.com/OmAsana/yapraktikum/internal/labels"
 	"github.com/OmAsana/yapraktikum/internal/logging"
+	"github.com/OmAsana/yapraktikum/internal/metrics"
 )
 
 type Option func(*Agent) error
 
+// WithAddress sets the server address. A comma separated list sets several
+// addresses, see WithReportMode.
 func WithAddress(address string) Option {
 	return func(agent *Agent) error {
-		if !strings.HasPrefix(address, "http://") {
-			address = "http://" + address
+		var urls []*url.URL
+		for _, addr := range splitList(address) {
+			if !strings.HasPrefix(addr, "http://") {
+				addr = "http://" + addr
+			}
 
+			u, err := url.Parse(addr)
+			if err != nil {
+				return err
+			}
+			urls = append(urls, u)
 		}
+		if len(urls) == 0 {
+			return fmt.Errorf("no server address")
+		}
+		agent.cfg.BaseURLs = urls
+		return nil
+	}
+}
 
-		defaultBaseURL, err := url.Parse(address)
-		if err != nil {
-			return err
+// WithReportMode chooses how several addresses are used: ReportModeFailover
+// or ReportModeFanout. Failover picks the first server answering /ping, a
+// failed database check of the server does not rule it out.
+func WithReportMode(mode string) Option {
+	return func(agent *Agent) error {
+		if mode != ReportModeFailover && mode != ReportModeFanout {
+			return fmt.Errorf("unknown report mode %q", mode)
 		}
-		agent.cfg.BaseURL = defaultBaseURL
+		agent.cfg.ReportMode = mode
 		return nil
 	}
 }
@@ -53,3 +79,227 @@ func WithHashKey(key string) Option {
 		return nil
 	}
 }
+
+func WithLabels(l string) Option {
+	return func(agent *Agent) error {
+		lbls, err := labels.Parse(l)
+		if err != nil {
+			return err
+		}
+		agent.cfg.Labels = lbls
+		return nil
+	}
+}
+
+// WithGRPCAddress makes the agent report over gRPC instead of HTTP.
+// Empty address keeps the HTTP transport.
+func WithGRPCAddress(address string) Option {
+	return func(agent *Agent) error {
+		if address == "" {
+			return nil
+		}
+
+		client, err := newGRPCClient(address)
+		if err != nil {
+			return err
+		}
+		agent.cfg.GRPCAddress = address
+		agent.grpcClient = client
+		return nil
+	}
+}
+
+// WithCryptoKey encrypts batch updates with the PEM public key at path.
+// Empty path sends them in plain text.
+func WithCryptoKey(path string) Option {
+	return func(agent *Agent) error {
+		if path == "" {
+			return nil
+		}
+
+		key, err := encrypt.LoadPublicKey(path)
+		if err != nil {
+			return err
+		}
+		agent.cfg.PublicKey = key
+		return nil
+	}
+}
+
+// WithCompression gzips batch updates. The agent falls back to plain JSON
+// if the server rejects a gzip batch with 400 or 415 but accepts it
+// uncompressed.
+func WithCompression(compress bool) Option {
+	return func(agent *Agent) error {
+		agent.cfg.Compress = compress
+		return nil
+	}
+}
+
+// WithOutbox queues batches that could not be delivered in dir and replays
+// them once the server is back. Empty dir keeps failed deltas in memory
+// until the next report. In fanout mode every address gets a subdirectory.
+func WithOutbox(dir string, maxSize int64, maxAge time.Duration) Option {
+	return func(agent *Agent) error {
+		agent.cfg.OutboxDir = dir
+		agent.cfg.OutboxMaxSize = maxSize
+		agent.cfg.OutboxMaxAge = maxAge
+		return nil
+	}
+}
+
+// WithDisabledCollectors turns off collectors listed in a comma separated
+// string of names.
+func WithDisabledCollectors(names string) Option {
+	return func(agent *Agent) error {
+		return agent.registry.DisableCollectors(splitList(names)...)
+	}
+}
+
+func WithCollectorTimeout(t time.Duration) Option {
+	return func(agent *Agent) error {
+		agent.registry.SetCollectorTimeout(t)
+		return nil
+	}
+}
+
+// WithProcesses watches processes whose name matches any of comma separated
+// regular expressions or whose pid is in any of comma separated PID files.
+func WithProcesses(patterns string, pidFiles string) Option {
+	return func(agent *Agent) error {
+		if patterns == "" && pidFiles == "" {
+			return nil
+		}
+
+		c, err := metrics.NewProcessCollector(splitList(patterns), splitList(pidFiles))
+		if err != nil {
+			return err
+		}
+		agent.registry.AddCollector(c)
+		return nil
+	}
+}
+
+func splitList(s string) []string {
+	var result []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// WithPlugins runs semicolon separated commands on every poll and reports
+// metrics they print. Every command may run for at most timeout.
+func WithPlugins(commands string, timeout time.Duration) Option {
+	return func(agent *Agent) error {
+		parsed := metrics.ParseExecCommands(commands)
+		if len(parsed) == 0 {
+			return nil
+		}
+		agent.registry.AddCollector(metrics.NewExecCollector(parsed, timeout))
+		return nil
+	}
+}
+
+// WithStatsD listens for StatsD packets on a UDP address. Empty address
+// disables the listener.
+func WithStatsD(address string) Option {
+	return func(agent *Agent) error {
+		if address == "" {
+			return nil
+		}
+
+		l, err := newStatsdListener(address, agent.log)
+		if err != nil {
+			return err
+		}
+		agent.statsd = l
+		agent.registry.AddCollector(l)
+		return nil
+	}
+}
+
+// WithPush accepts metrics from local applications over HTTP on address and
+// relays them signed with the agent's hash key. Empty address disables the
+// endpoint.
+func WithPush(address string) Option {
+	return func(agent *Agent) error {
+		if address == "" {
+			return nil
+		}
+
+		l, err := newPushListener(address, agent.log)
+		if err != nil {
+			return err
+		}
+		if ip := l.listener.Addr().(*net.TCPAddr).IP; !ip.IsLoopback() {
+			agent.log.S().Warnf("Push endpoint %s accepts metrics from anyone who can reach it", l.listener.Addr())
+		}
+		agent.push = l
+		agent.registry.AddCollector(l)
+		return nil
+	}
+}
+
+// WithRetry retries transient failures of HTTP requests. attempts counts the
+// first one, so 1 disables retries. jitter is the fraction, from 0 to 1, by
+// which every delay may be randomly shortened.
+func WithRetry(attempts int, baseDelay time.Duration, maxDelay time.Duration, jitter float64) Option {
+	return func(agent *Agent) error {
+		if attempts < 1 {
+			return fmt.Errorf("retry attempts must be at least 1, got %d", attempts)
+		}
+		if baseDelay < 0 || maxDelay < baseDelay {
+			return fmt.Errorf("invalid retry delays: base %s, max %s", baseDelay, maxDelay)
+		}
+		if jitter < 0 || jitter > 1 {
+			return fmt.Errorf("retry jitter must be between 0 and 1, got %g", jitter)
+		}
+		agent.cfg.Retry = retryPolicy{
+			Attempts:  attempts,
+			BaseDelay: baseDelay,
+			MaxDelay:  maxDelay,
+			Jitter:    jitter,
+		}
+		return nil
+	}
+}
+
+// WithRateLimit caps the number of concurrent requests to the server.
+func WithRateLimit(limit int) Option {
+	return func(agent *Agent) error {
+		if limit < 1 {
+			return fmt.Errorf("rate limit must be at least 1, got %d", limit)
+		}
+		agent.cfg.RateLimit = limit
+		return nil
+	}
+}
+
+// WithBatchUpdates chooses between a single /updates/ request per report and
+// a request per metric to /update/. Per metric updates can not be encrypted.
+func WithBatchUpdates(enabled bool) Option {
+	return func(agent *Agent) error {
+		agent.cfg.Batch = enabled
+		return nil
+	}
+}
+
+// WithDebugAddress serves the agent's own telemetry on address at
+// /debug/telemetry. Empty address disables the endpoint.
+func WithDebugAddress(address string) Option {
+	return func(agent *Agent) error {
+		if address == "" {
+			return nil
+		}
+
+		s, err := newDebugServer(address, agent.telemetry, agent.log)
+		if err != nil {
+			return err
+		}
+		agent.debug = s
+		return nil
+	}
+}
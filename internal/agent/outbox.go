@@ -0,0 +1,286 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OmAsana/yapraktikum/internal/handlers"
+	"github.com/OmAsana/yapraktikum/internal/logging"
+)
+
+const (
+	outboxBackoffBase = time.Second
+	outboxBackoffMax  = 5 * time.Minute
+	outboxFileSuffix  = ".json"
+)
+
+var errOutboxBackoff = errors.New("outbox is backing off")
+
+// queuedBatch is the on-disk form of a batch that could not be delivered.
+// Key is the idempotency key the batch was first sent with.
+type queuedBatch struct {
+	Created time.Time           `json:"created"`
+	Key     string              `json:"key,omitempty"`
+	Metrics []*handlers.Metrics `json:"metrics"`
+}
+
+// outbox is a bounded disk-backed FIFO of undelivered batches. Every batch is
+// kept in its own file named by a sequence number, so lexical order of file
+// names is the order batches were queued in.
+type outbox struct {
+	mu sync.Mutex
+	// replayMu is held for the whole replay, so batches are sent in order.
+	replayMu sync.Mutex
+
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	log     *logging.Logger
+
+	seq         uint64
+	failures    int
+	nextAttempt time.Time
+	now         func() time.Time
+}
+
+func newOutbox(dir string, maxSize int64, maxAge time.Duration, log *logging.Logger) (*outbox, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	o := &outbox{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		log:     log,
+		now:     time.Now,
+	}
+
+	files, err := o.files()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		last := strings.TrimSuffix(files[len(files)-1].Name(), outboxFileSuffix)
+		o.seq, err = strconv.ParseUint(last, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected file in outbox: %s", files[len(files)-1].Name())
+		}
+	}
+	return o, nil
+}
+
+// push appends batch to the queue and drops the oldest batches if the queue
+// grows past maxSize.
+func (o *outbox) push(batch []*handlers.Metrics, key string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	data, err := json.Marshal(queuedBatch{Created: o.now(), Key: key, Metrics: batch})
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > o.maxSize {
+		return fmt.Errorf("batch of %d bytes exceeds outbox size %d", len(data), o.maxSize)
+	}
+
+	o.seq++
+	name := filepath.Join(o.dir, fmt.Sprintf("%020d%s", o.seq, outboxFileSuffix))
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+
+	return o.truncate()
+}
+
+// truncate removes the oldest batches until the queue fits into maxSize.
+func (o *outbox) truncate() error {
+	files, err := o.files()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	sizes := make([]int64, len(files))
+	for i, f := range files {
+		info, err := f.Info()
+		if err != nil {
+			return err
+		}
+		sizes[i] = info.Size()
+		total += sizes[i]
+	}
+
+	for i := 0; total > o.maxSize && i < len(files); i++ {
+		o.log.S().Warnf("Outbox is full, dropping batch %s", files[i].Name())
+		if err := os.Remove(filepath.Join(o.dir, files[i].Name())); err != nil {
+			return err
+		}
+		total -= sizes[i]
+	}
+	return nil
+}
+
+// replay sends queued batches oldest first and removes every delivered one.
+// It stops at the first error and backs off exponentially before the next
+// attempt. Batches older than maxAge are dropped without sending.
+//
+// The outbox is not locked while sending, only one replay runs at a time.
+func (o *outbox) replay(send func(batch []*handlers.Metrics, key string) error) error {
+	o.replayMu.Lock()
+	defer o.replayMu.Unlock()
+
+	pending, err := o.load()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pending {
+		if err := send(p.batch.Metrics, p.batch.Key); err != nil {
+			o.failed()
+			return err
+		}
+		if err := o.remove(p.path); err != nil {
+			return err
+		}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.failures = 0
+	o.nextAttempt = time.Time{}
+	return nil
+}
+
+type pendingBatch struct {
+	path  string
+	batch queuedBatch
+}
+
+// load reads queued batches to replay, dropping corrupted and expired ones.
+func (o *outbox) load() ([]pendingBatch, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	files, err := o.files()
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+	if o.now().Before(o.nextAttempt) {
+		return nil, errOutboxBackoff
+	}
+
+	pending := make([]pendingBatch, 0, len(files))
+	for _, f := range files {
+		path := filepath.Join(o.dir, f.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var queued queuedBatch
+		if err := json.Unmarshal(data, &queued); err != nil {
+			o.log.S().Errorf("Dropping corrupted batch %s: %s", f.Name(), err)
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if o.maxAge > 0 && o.now().Sub(queued.Created) > o.maxAge {
+			o.log.S().Warnf("Dropping batch %s queued at %s", f.Name(), queued.Created.Format(time.RFC3339))
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		pending = append(pending, pendingBatch{path: path, batch: queued})
+	}
+	return pending, nil
+}
+
+// remove deletes a delivered batch. It may be gone already if push dropped
+// it meanwhile to make room.
+func (o *outbox) remove(path string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// failed postpones the next replay after a delivery failure.
+func (o *outbox) failed() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delay := outboxBackoffBase << o.failures
+	if delay > outboxBackoffMax || delay <= 0 {
+		delay = outboxBackoffMax
+	} else {
+		o.failures++
+	}
+	o.nextAttempt = o.now().Add(delay)
+}
+
+// len returns the number of queued batches.
+func (o *outbox) len() (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	files, err := o.files()
+	return len(files), err
+}
+
+// size returns the total size of queued batches in bytes.
+func (o *outbox) size() (int64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	files, err := o.files()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, f := range files {
+		info, err := f.Info()
+		if err != nil {
+			return 0, err
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
+
+func (o *outbox) files() ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := entries[:0]
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), outboxFileSuffix) {
+			files = append(files, e)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+	return files, nil
+}
@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/OmAsana/yapraktikum/internal/handlers"
+	"github.com/OmAsana/yapraktikum/internal/logging"
+	"github.com/OmAsana/yapraktikum/internal/pkg"
+)
+
+func testBatch(id string) []*handlers.Metrics {
+	return []*handlers.Metrics{{ID: id, MType: "gauge", Value: pkg.PointerFloat(1)}}
+}
+
+func TestOutbox(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	newTestOutbox := func(t *testing.T, dir string, maxSize int64) *outbox {
+		o, err := newOutbox(dir, maxSize, time.Hour, logging.NewNoop())
+		require.NoError(t, err)
+		o.now = func() time.Time { return now }
+		return o
+	}
+
+	collect := func(o *outbox) ([]string, error) {
+		var sent []string
+		err := o.replay(func(batch []*handlers.Metrics, key string) error {
+			sent = append(sent, batch[0].ID)
+			return nil
+		})
+		return sent, err
+	}
+
+	t.Run("replays in order after reopen", func(t *testing.T) {
+		dir := t.TempDir()
+		o := newTestOutbox(t, dir, 1<<20)
+		require.NoError(t, o.push(testBatch("a"), ""))
+		require.NoError(t, o.push(testBatch("b"), ""))
+
+		o = newTestOutbox(t, dir, 1<<20)
+		require.NoError(t, o.push(testBatch("c"), ""))
+
+		sent, err := collect(o)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "b", "c"}, sent)
+		n, err := o.len()
+		require.NoError(t, err)
+		assert.Zero(t, n)
+	})
+
+	t.Run("keeps idempotency keys", func(t *testing.T) {
+		dir := t.TempDir()
+		o := newTestOutbox(t, dir, 1<<20)
+		require.NoError(t, o.push(testBatch("a"), "key-a"))
+		require.NoError(t, o.push(testBatch("b"), ""))
+
+		o = newTestOutbox(t, dir, 1<<20)
+		var keys []string
+		require.NoError(t, o.replay(func(batch []*handlers.Metrics, key string) error {
+			keys = append(keys, key)
+			return nil
+		}))
+		assert.Equal(t, []string{"key-a", ""}, keys)
+	})
+
+	t.Run("drops oldest when full", func(t *testing.T) {
+		dir := t.TempDir()
+		o := newTestOutbox(t, dir, 1<<20)
+		require.NoError(t, o.push(testBatch("a"), ""))
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		info, err := files[0].Info()
+		require.NoError(t, err)
+
+		o.maxSize = 2 * info.Size()
+		require.NoError(t, o.push(testBatch("b"), ""))
+		require.NoError(t, o.push(testBatch("c"), ""))
+
+		sent, err := collect(o)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"b", "c"}, sent)
+	})
+
+	t.Run("drops expired", func(t *testing.T) {
+		o := newTestOutbox(t, t.TempDir(), 1<<20)
+		require.NoError(t, o.push(testBatch("old"), ""))
+		now = now.Add(2 * time.Hour)
+		require.NoError(t, o.push(testBatch("new"), ""))
+
+		sent, err := collect(o)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"new"}, sent)
+	})
+
+	t.Run("backs off after failure", func(t *testing.T) {
+		o := newTestOutbox(t, t.TempDir(), 1<<20)
+		require.NoError(t, o.push(testBatch("a"), ""))
+		require.NoError(t, o.push(testBatch("b"), ""))
+
+		var attempts int
+		failing := func(batch []*handlers.Metrics, key string) error {
+			attempts++
+			return errors.New("server is down")
+		}
+		assert.Error(t, o.replay(failing))
+		assert.ErrorIs(t, o.replay(failing), errOutboxBackoff)
+		assert.Equal(t, 1, attempts)
+
+		now = now.Add(outboxBackoffBase)
+		assert.Error(t, o.replay(failing))
+		now = now.Add(outboxBackoffBase)
+		assert.ErrorIs(t, o.replay(failing), errOutboxBackoff)
+		assert.Equal(t, 2, attempts)
+
+		now = now.Add(outboxBackoffBase)
+		sent, err := collect(o)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "b"}, sent)
+	})
+
+	t.Run("rejects oversized batch", func(t *testing.T) {
+		o := newTestOutbox(t, t.TempDir(), 10)
+		assert.Error(t, o.push(testBatch("a"), ""))
+		_, err := os.Stat(filepath.Join(o.dir, "00000000000000000001.json"))
+		assert.True(t, os.IsNotExist(err))
+	})
+}
@@ -0,0 +1,212 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/OmAsana/yapraktikum/internal/handlers"
+	"github.com/OmAsana/yapraktikum/internal/logging"
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+	"github.com/OmAsana/yapraktikum/internal/pkg"
+)
+
+const (
+	pushMaxBodySize = 10 << 20
+	shutdownTimeout = 5 * time.Second
+)
+
+// pushListener accepts metrics from co-located applications on the /update/
+// and /updates/ endpoints of MetricsServer and buffers them until the next
+// poll. Hashes sent by applications are ignored, the agent signs everything
+// it forwards with its own key.
+type pushListener struct {
+	listener net.Listener
+	server   *http.Server
+	log      *logging.Logger
+
+	mu         sync.Mutex
+	counters   map[string]metrics.Counter
+	gauges     map[string]metrics.Gauge
+	histograms map[string]metrics.Histogram
+}
+
+var _ metrics.DrainingCollector = (*pushListener)(nil)
+
+func newPushListener(address string, log *logging.Logger) (*pushListener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &pushListener{
+		listener:   listener,
+		log:        log,
+		counters:   map[string]metrics.Counter{},
+		gauges:     map[string]metrics.Gauge{},
+		histograms: map[string]metrics.Histogram{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/update/", l.update)
+	mux.HandleFunc("/updates/", l.updates)
+	l.server = &http.Server{Handler: mux}
+	return l, nil
+}
+
+func (l *pushListener) Name() string {
+	return "push"
+}
+
+func (l *pushListener) Drains() {}
+
+func (l *pushListener) Collect(ctx context.Context) (metrics.Collection, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var result metrics.Collection
+	for _, g := range l.gauges {
+		result.Gauges = append(result.Gauges, g)
+	}
+	for _, c := range l.counters {
+		result.Counters = append(result.Counters, c)
+	}
+	for _, h := range l.histograms {
+		result.Histograms = append(result.Histograms, h)
+	}
+
+	l.counters = map[string]metrics.Counter{}
+	l.histograms = map[string]metrics.Histogram{}
+	return result, nil
+}
+
+// serve accepts requests until ctx is done.
+func (l *pushListener) serve(ctx context.Context) {
+	serveHTTP(ctx, l.server, l.listener, l.log)
+}
+
+func (l *pushListener) update(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost || request.URL.Path != "/update/" {
+		http.NotFound(writer, request)
+		return
+	}
+
+	var m handlers.Metrics
+	if err := json.NewDecoder(http.MaxBytesReader(writer, request.Body, pushMaxBodySize)).Decode(&m); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	l.store(writer, []handlers.Metrics{m})
+}
+
+func (l *pushListener) updates(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost || request.URL.Path != "/updates/" {
+		http.NotFound(writer, request)
+		return
+	}
+
+	var metricList []handlers.Metrics
+	if err := json.NewDecoder(http.MaxBytesReader(writer, request.Body, pushMaxBodySize)).Decode(&metricList); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	l.store(writer, metricList)
+}
+
+// store buffers the whole batch or, if any metric in it is invalid, nothing.
+func (l *pushListener) store(writer http.ResponseWriter, metricList []handlers.Metrics) {
+	for _, m := range metricList {
+		if err := validatePushed(m); err != nil {
+			http.Error(writer, fmt.Sprintf("%s: %s", m.ID, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Counters and histograms are merged into copies first, so an overflow or
+	// a bucket mismatch does not leave half of the batch buffered.
+	counters := make(map[string]metrics.Counter, len(l.counters))
+	for k, c := range l.counters {
+		counters[k] = c
+	}
+	for _, m := range metricList {
+		if m.MType != "counter" {
+			continue
+		}
+		c := metrics.CounterFromHandler(m)
+		buffered := counters[c.SeriesKey()]
+		if buffered.Value > math.MaxInt64-c.Value {
+			http.Error(writer, fmt.Sprintf("%s: counter overflows", m.ID), http.StatusBadRequest)
+			return
+		}
+		c.Value += buffered.Value
+		counters[c.SeriesKey()] = c
+	}
+
+	histograms := make(map[string]metrics.Histogram, len(l.histograms))
+	for k, h := range l.histograms {
+		histograms[k] = h
+	}
+	for _, m := range metricList {
+		if m.MType != "histogram" {
+			continue
+		}
+		h := metrics.HistogramFromHandler(m)
+		buffered, ok := histograms[h.SeriesKey()]
+		if !ok {
+			histograms[h.SeriesKey()] = h
+			continue
+		}
+		if err := buffered.Merge(h); err != nil {
+			http.Error(writer, fmt.Sprintf("%s: %s", m.ID, err), http.StatusBadRequest)
+			return
+		}
+		histograms[h.SeriesKey()] = buffered
+	}
+	l.counters = counters
+	l.histograms = histograms
+
+	for _, m := range metricList {
+		if m.MType == "gauge" {
+			g := metrics.GaugeFromHandler(m)
+			l.gauges[g.SeriesKey()] = g
+		}
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+func validatePushed(m handlers.Metrics) error {
+	if err := metrics.CheckName(m.ID); err != nil {
+		return err
+	}
+
+	switch m.MType {
+	case "counter":
+		if m.Delta == nil {
+			return fmt.Errorf("delta can not be nil")
+		}
+		return metrics.CounterFromHandler(m).IsValid()
+	case "gauge":
+		if m.Value == nil {
+			return fmt.Errorf("value can not be nil")
+		}
+		if !pkg.FloatIsNumber(*m.Value) {
+			return fmt.Errorf("gauge value must be a number")
+		}
+	case "histogram":
+		if m.Sum == nil || m.Count == nil {
+			return fmt.Errorf("sum and count can not be nil")
+		}
+		return metrics.HistogramFromHandler(m).IsValid()
+	default:
+		return fmt.Errorf("wrong metric type")
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/OmAsana/yapraktikum/internal/labels"
+	"github.com/OmAsana/yapraktikum/internal/server"
+)
+
+func TestAgent_Push(t *testing.T) {
+	repo := SetupRepo(t)
+	handler, err := server.NewMetricsServer(repo, server.WithHashKey("key"))
+	require.NoError(t, err)
+	metricServer := httptest.NewServer(handler)
+	defer metricServer.Close()
+
+	agent, err := NewAgentWithOptions(WithAddress(metricServer.URL), WithHashKey("key"), WithPush("127.0.0.1:0"))
+	require.NoError(t, err)
+	require.NoError(t, agent.registry.DisableCollectors("runtime", "memory", "cpu", "disk", "diskio", "net", "load", "uptime"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go agent.push.serve(ctx)
+
+	pushURL := "http://" + agent.push.listener.Addr().String()
+	post := func(path, body string) int {
+		resp, err := http.Post(pushURL+path, "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, post("/update/", `{"id":"jobs","type":"counter","delta":2}`))
+	assert.Equal(t, http.StatusOK, post("/updates/", `[
+		{"id":"jobs","type":"counter","delta":3},
+		{"id":"queue","type":"gauge","value":7.5,"labels":{"queue":"mail"}},
+		{"id":"latency","type":"histogram","buckets":[1,10],"counts":[1,1,0],"sum":6,"count":2}
+	]`))
+
+	// A batch is buffered as a whole or not at all.
+	assert.Equal(t, http.StatusBadRequest, post("/updates/", `[
+		{"id":"jobs","type":"counter","delta":100},
+		{"id":"latency","type":"histogram","buckets":[5],"counts":[1,0],"sum":1,"count":1}
+	]`))
+	assert.Equal(t, http.StatusBadRequest, post("/updates/", `[
+		{"id":"jobs","type":"counter","delta":1},
+		{"id":"jobs","type":"counter","delta":9223372036854775807}
+	]`))
+	assert.Equal(t, http.StatusBadRequest, post("/update/", `{"id":"jobs","type":"counter","delta":-1}`))
+	assert.Equal(t, http.StatusBadRequest, post("/update/", `{"id":"jobs","type":"summary"}`))
+	assert.Equal(t, http.StatusBadRequest, post("/updates/", `not json`))
+	assert.Equal(t, http.StatusBadRequest, post("/update/", `{"id":"agent_reports_sent","type":"counter","delta":1}`))
+	assert.Equal(t, http.StatusNotFound, post("/update/counter/jobs/1", ``))
+
+	require.NoError(t, agent.registry.Collect(ctx))
+	runReports(ctx, agent)
+
+	jobs, err := repo.RetrieveCounter(context.Background(), "jobs", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), jobs.Value)
+
+	queue, err := repo.RetrieveGauge(context.Background(), "queue", labels.Labels{"queue": "mail"})
+	require.NoError(t, err)
+	assert.Equal(t, 7.5, queue.Value)
+
+	latency, err := repo.RetrieveHistogram(context.Background(), "latency", nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), latency.Count)
+	assert.Equal(t, 6.0, latency.Sum)
+}
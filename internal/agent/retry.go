@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryPolicy controls how sendRequest retries transient failures. Delays
+// grow exponentially from BaseDelay up to MaxDelay, and each one is shortened
+// by a random fraction of up to Jitter so agents restarted together do not
+// retry in lockstep.
+type retryPolicy struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Jitter    float64
+}
+
+// delay returns the pause before the attempt following attempt, counting
+// from 1.
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := p.MaxDelay
+	if shift := attempt - 1; shift < 32 {
+		if exp := p.BaseDelay << shift; exp > 0 && exp < p.MaxDelay {
+			d = exp
+		}
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// retryable reports whether the request may succeed if sent again: the
+// connection failed, the server is overloaded or failed to handle it.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var respErr *responseError
+	if errors.As(err, &respErr) {
+		code := respErr.StatusCode
+		return code == http.StatusTooManyRequests || (code >= 500 && code != http.StatusNotImplemented)
+	}
+	return true
+}
+
+// parseRetryAfter reads Retry-After given either in seconds or as an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := date.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// sleep waits for d unless ctx is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("retry interrupted: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyServer fails the first failures requests with fail and counts all
+// requests. Every request must carry body.
+func flakyServer(t *testing.T, failures int32, body string, fail http.HandlerFunc) (*httptest.Server, *int32) {
+	t.Helper()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(got))
+
+		if atomic.AddInt32(&requests, 1) <= failures {
+			fail(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func statusHandler(code int, retryAfter string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		http.Error(w, http.StatusText(code), code)
+	}
+}
+
+func dropConnection(w http.ResponseWriter, r *http.Request) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+}
+
+func TestAgent_SendRequestRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int32
+		fail         http.HandlerFunc
+		wantErr      bool
+		wantRequests int32
+	}{
+		{name: "no failures", failures: 0, fail: statusHandler(http.StatusServiceUnavailable, ""), wantRequests: 1},
+		{name: "server errors", failures: 2, fail: statusHandler(http.StatusInternalServerError, ""), wantRequests: 3},
+		{name: "too many requests", failures: 1, fail: statusHandler(http.StatusTooManyRequests, "3600"), wantRequests: 2},
+		{name: "dropped connections", failures: 2, fail: dropConnection, wantRequests: 3},
+		{name: "attempts exhausted", failures: 5, fail: statusHandler(http.StatusBadGateway, ""), wantErr: true, wantRequests: 3},
+		{name: "bad request", failures: 1, fail: statusHandler(http.StatusBadRequest, ""), wantErr: true, wantRequests: 1},
+		{name: "not implemented", failures: 1, fail: statusHandler(http.StatusNotImplemented, ""), wantErr: true, wantRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `[{"id":"Alloc","type":"gauge","value":1}]`
+			srv, requests := flakyServer(t, tt.failures, body, tt.fail)
+
+			agent, err := NewAgentWithOptions(WithAddress(srv.URL), WithRetry(3, time.Millisecond, 20*time.Millisecond, 0.5))
+			require.NoError(t, err)
+
+			req, err := agent.jsonRequest(context.Background(), agent.cfg.BaseURLs[0], "/updates/", bytes.NewReader([]byte(body)))
+			require.NoError(t, err)
+
+			start := time.Now()
+			err = agent.sendRequest(req)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantRequests, atomic.LoadInt32(requests))
+			// Retry-After is capped by the max delay.
+			assert.Less(t, time.Since(start), time.Second)
+		})
+	}
+}
+
+func TestAgent_SendRequestCanceled(t *testing.T) {
+	srv, requests := flakyServer(t, 100, "", statusHandler(http.StatusServiceUnavailable, ""))
+
+	agent, err := NewAgentWithOptions(WithAddress(srv.URL), WithRetry(5, time.Hour, time.Hour, 0))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, err := agent.jsonRequest(ctx, agent.cfg.BaseURLs[0], "/updates/", nil)
+	require.NoError(t, err)
+
+	start := time.Now()
+	err = agent.sendRequest(req)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(requests))
+}
+
+func TestRetryPolicy_delay(t *testing.T) {
+	p := retryPolicy{Attempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	assert.Equal(t, 100*time.Millisecond, p.delay(1))
+	assert.Equal(t, 200*time.Millisecond, p.delay(2))
+	assert.Equal(t, 800*time.Millisecond, p.delay(4))
+	assert.Equal(t, time.Second, p.delay(5))
+	assert.Equal(t, time.Second, p.delay(100))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.delay(2)
+		assert.True(t, d > 100*time.Millisecond && d <= 200*time.Millisecond, d)
+	}
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOk bool
+	}{
+		{value: "", wantOk: false},
+		{value: "120", want: 2 * time.Minute, wantOk: true},
+		{value: "-1", wantOk: false},
+		{value: "Tue, 01 Mar 2022 12:00:30 GMT", want: 30 * time.Second, wantOk: true},
+		{value: "Tue, 01 Mar 2022 11:00:00 GMT", want: 0, wantOk: true},
+		{value: "soon", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := parseRetryAfter(tt.value, now)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
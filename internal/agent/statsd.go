@@ -0,0 +1,237 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/OmAsana/yapraktikum/internal/labels"
+	"github.com/OmAsana/yapraktikum/internal/logging"
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+	"github.com/OmAsana/yapraktikum/internal/pkg"
+)
+
+const (
+	statsdMaxPacketSize = 65535
+	// statsdMinSampleRate bounds how many events a single sampled line may
+	// stand for.
+	statsdMinSampleRate = 1e-6
+	// statsdMalformedLines counts lines the listener could not parse.
+	statsdMalformedLines = metrics.ReservedPrefix + "statsd_malformed_lines"
+)
+
+// statsdTimerBuckets are upper bounds of timer histograms in milliseconds.
+var statsdTimerBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// statsdSample is a single parsed StatsD line.
+type statsdSample struct {
+	name     string
+	kind     string
+	value    float64
+	relative bool
+	rate     float64
+	labels   labels.Labels
+}
+
+// statsdListener aggregates StatsD packets between polls. It is a collector,
+// so aggregated metrics reach the registry on every poll: counter increments
+// and timer observations as deltas, gauges with their latest value.
+type statsdListener struct {
+	conn net.PacketConn
+	log  *logging.Logger
+
+	mu        sync.Mutex
+	counters  map[string]metrics.Counter
+	gauges    map[string]metrics.Gauge
+	timers    map[string]metrics.Histogram
+	malformed int64
+}
+
+var _ metrics.DrainingCollector = (*statsdListener)(nil)
+
+func newStatsdListener(address string, log *logging.Logger) (*statsdListener, error) {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &statsdListener{
+		conn:     conn,
+		log:      log,
+		counters: map[string]metrics.Counter{},
+		gauges:   map[string]metrics.Gauge{},
+		timers:   map[string]metrics.Histogram{},
+	}, nil
+}
+
+func (l *statsdListener) Name() string {
+	return "statsd"
+}
+
+func (l *statsdListener) Drains() {}
+
+func (l *statsdListener) Collect(ctx context.Context) (metrics.Collection, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var result metrics.Collection
+	for _, g := range l.gauges {
+		result.Gauges = append(result.Gauges, g)
+	}
+	for _, c := range l.counters {
+		result.Counters = append(result.Counters, c)
+	}
+	for _, h := range l.timers {
+		result.Histograms = append(result.Histograms, h)
+	}
+	result.Counters = append(result.Counters, metrics.Counter{Name: statsdMalformedLines, Value: l.malformed})
+
+	l.counters = map[string]metrics.Counter{}
+	l.timers = map[string]metrics.Histogram{}
+	l.malformed = 0
+	return result, nil
+}
+
+// serve reads packets until ctx is done.
+func (l *statsdListener) serve(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		l.conn.Close()
+	}()
+
+	buf := make([]byte, statsdMaxPacketSize)
+	for {
+		n, _, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				l.log.S().Error("StatsD listener stopped: ", err)
+			}
+			return
+		}
+		l.handlePacket(string(buf[:n]))
+	}
+}
+
+func (l *statsdListener) handlePacket(packet string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, line := range strings.Split(packet, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		s, err := parseStatsdLine(line)
+		if err != nil {
+			l.log.S().Debugf("Malformed StatsD line %q: %s", line, err)
+			l.malformed++
+			continue
+		}
+		if err := l.add(s); err != nil {
+			l.log.S().Debugf("Dropping StatsD line %q: %s", line, err)
+			l.malformed++
+		}
+	}
+}
+
+// add aggregates s. Must be called with the lock held.
+func (l *statsdListener) add(s statsdSample) error {
+	key := labels.SeriesKey(s.name, s.labels)
+	switch s.kind {
+	case "c":
+		// float64(math.MaxInt64) rounds up to 2^63, which does not fit.
+		scaled := math.Round(s.value / s.rate)
+		if scaled >= math.MaxInt64 {
+			return fmt.Errorf("counter increment overflows")
+		}
+		c := l.counters[key]
+		if c.Value > math.MaxInt64-int64(scaled) {
+			return fmt.Errorf("counter %s overflows", s.name)
+		}
+		c.Name, c.Labels = s.name, s.labels
+		c.Value += int64(scaled)
+		l.counters[key] = c
+	case "g":
+		g := l.gauges[key]
+		if s.relative {
+			g.Value += s.value
+		} else {
+			g.Value = s.value
+		}
+		g.Name, g.Labels = s.name, s.labels
+		l.gauges[key] = g
+	case "ms":
+		h, ok := l.timers[key]
+		if !ok {
+			h = metrics.NewHistogram(s.name, statsdTimerBuckets)
+			h.Labels = s.labels
+		}
+		// A sampled timer stands for 1/rate observations.
+		n := uint64(math.Round(1 / s.rate))
+		if !pkg.FloatIsNumber(h.Sum + s.value*float64(n)) {
+			return fmt.Errorf("timer %s sum overflows", s.name)
+		}
+		h.ObserveN(s.value, n)
+		l.timers[key] = h
+	}
+	return nil
+}
+
+// parseStatsdLine parses "name:value|type[|@rate][|#tag:value,...]". Tags in
+// the DogStatsD format become labels.
+func parseStatsdLine(line string) (statsdSample, error) {
+	nameValue := strings.SplitN(line, ":", 2)
+	if len(nameValue) != 2 || nameValue[0] == "" {
+		return statsdSample{}, fmt.Errorf("expected name:value")
+	}
+
+	parts := strings.Split(nameValue[1], "|")
+	if len(parts) < 2 {
+		return statsdSample{}, fmt.Errorf("missing metric type")
+	}
+
+	if err := metrics.CheckName(nameValue[0]); err != nil {
+		return statsdSample{}, err
+	}
+
+	s := statsdSample{name: nameValue[0], kind: parts[1], rate: 1}
+	if s.kind != "c" && s.kind != "g" && s.kind != "ms" {
+		return statsdSample{}, fmt.Errorf("unsupported metric type %q", s.kind)
+	}
+
+	raw := parts[0]
+	s.relative = s.kind == "g" && (strings.HasPrefix(raw, "+") || strings.HasPrefix(raw, "-"))
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || !pkg.FloatIsNumber(value) {
+		return statsdSample{}, fmt.Errorf("invalid value %q", raw)
+	}
+	if value < 0 && (s.kind == "c" || s.kind == "ms") {
+		return statsdSample{}, fmt.Errorf("negative value %q", raw)
+	}
+	s.value = value
+
+	for _, field := range parts[2:] {
+		switch {
+		case strings.HasPrefix(field, "@"):
+			rate, err := strconv.ParseFloat(field[1:], 64)
+			if err != nil || rate < statsdMinSampleRate || rate > 1 {
+				return statsdSample{}, fmt.Errorf("invalid sample rate %q", field)
+			}
+			s.rate = rate
+		case strings.HasPrefix(field, "#"):
+			tags := strings.ReplaceAll(field[1:], ":", "=")
+			if s.labels, err = labels.Parse(tags); err != nil {
+				return statsdSample{}, err
+			}
+		default:
+			return statsdSample{}, fmt.Errorf("unexpected field %q", field)
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/OmAsana/yapraktikum/internal/labels"
+	"github.com/OmAsana/yapraktikum/internal/logging"
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+	"github.com/OmAsana/yapraktikum/internal/server"
+)
+
+func Test_parseStatsdLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    statsdSample
+		wantErr bool
+	}{
+		{line: "requests:1|c", want: statsdSample{name: "requests", kind: "c", value: 1, rate: 1}},
+		{line: "requests:2|c|@0.5", want: statsdSample{name: "requests", kind: "c", value: 2, rate: 0.5}},
+		{line: "queue:12.5|g", want: statsdSample{name: "queue", kind: "g", value: 12.5, rate: 1}},
+		{line: "queue:-3|g", want: statsdSample{name: "queue", kind: "g", value: -3, relative: true, rate: 1}},
+		{line: "latency:320|ms|#route:login", want: statsdSample{name: "latency", kind: "ms", value: 320, rate: 1, labels: labels.Labels{"route": "login"}}},
+		{line: "requests", wantErr: true},
+		{line: "requests:1", wantErr: true},
+		{line: "requests:1|s", wantErr: true},
+		{line: "requests:abc|c", wantErr: true},
+		{line: "requests:-1|c", wantErr: true},
+		{line: "requests:1|c|@0", wantErr: true},
+		{line: "requests:1|c|@2", wantErr: true},
+		{line: "latency:1|ms|@1e-12", wantErr: true},
+		{line: "requests:1|c|junk", wantErr: true},
+		{line: ":1|c", wantErr: true},
+		{line: "agent_collections:1|c", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := parseStatsdLine(tt.line)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStatsdListener_handlePacket(t *testing.T) {
+	l := &statsdListener{
+		log:      logging.NewNoop(),
+		counters: map[string]metrics.Counter{},
+		gauges:   map[string]metrics.Gauge{},
+		timers:   map[string]metrics.Histogram{},
+	}
+
+	l.handlePacket("requests:2|c|@0.5\nlatency:3|ms|@0.000001\nhuge:1e300|c|@0.5\nrequests:9223372036854775807|c\nbig:9e18|c\nbig:9e18|c")
+	assert.Equal(t, int64(4), l.counters["requests"].Value)
+	assert.NotContains(t, l.counters, "huge")
+	assert.Equal(t, uint64(1000000), l.timers["latency"].Count)
+	assert.Equal(t, 3e6, l.timers["latency"].Sum)
+	assert.Equal(t, int64(9e18), l.counters["big"].Value)
+	assert.Equal(t, int64(3), l.malformed)
+}
+
+func TestAgent_StatsD(t *testing.T) {
+	repo := SetupRepo(t)
+	handler, err := server.NewMetricsServer(repo)
+	require.NoError(t, err)
+	metricServer := httptest.NewServer(handler)
+	defer metricServer.Close()
+
+	agent, err := NewAgentWithOptions(WithAddress(metricServer.URL), WithStatsD("127.0.0.1:0"))
+	require.NoError(t, err)
+	require.NoError(t, agent.registry.DisableCollectors("runtime", "memory", "cpu", "disk", "diskio", "net", "load", "uptime"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go agent.statsd.serve(ctx)
+
+	conn, err := net.Dial("udp", agent.statsd.conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	packets := []string{
+		"requests:1|c\nrequests:2|c|@0.5\nqueue:10|g",
+		"queue:+5|g\nlatency:20|ms\nlatency:700|ms|#route:login",
+		"garbage\nrequests:x|c",
+	}
+	for _, p := range packets {
+		_, err := conn.Write([]byte(p))
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool {
+		agent.statsd.mu.Lock()
+		defer agent.statsd.mu.Unlock()
+		return agent.statsd.malformed == 2
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, agent.registry.Collect(ctx))
+	runReports(ctx, agent)
+
+	requests, err := repo.RetrieveCounter(context.Background(), "requests", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), requests.Value)
+
+	malformed, err := repo.RetrieveCounter(context.Background(), "agent_statsd_malformed_lines", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), malformed.Value)
+
+	queue, err := repo.RetrieveGauge(context.Background(), "queue", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 15.0, queue.Value)
+
+	latency, err := repo.RetrieveHistogram(context.Background(), "latency", labels.Labels{"route": "login"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), latency.Count)
+	assert.Equal(t, 700.0, latency.Sum)
+
+	// Counters are flushed into the registry once, gauges stay.
+	require.NoError(t, agent.registry.Collect(ctx))
+	runReports(ctx, agent)
+	requests, err = repo.RetrieveCounter(context.Background(), "requests", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), requests.Value)
+	queue, err = repo.RetrieveGauge(context.Background(), "queue", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 15.0, queue.Value)
+}
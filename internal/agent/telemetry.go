@@ -0,0 +1,229 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/OmAsana/yapraktikum/internal/handlers"
+	"github.com/OmAsana/yapraktikum/internal/labels"
+	"github.com/OmAsana/yapraktikum/internal/logging"
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+)
+
+const (
+	telemetryCollections      = metrics.ReservedPrefix + "collections"
+	telemetryCollectionErrors = metrics.ReservedPrefix + "collection_errors"
+	telemetryReportsSent      = metrics.ReservedPrefix + "reports_sent"
+	telemetryReportsFailed    = metrics.ReservedPrefix + "reports_failed"
+	telemetryRequestLatency   = metrics.ReservedPrefix + "request_latency_ms"
+	telemetryBatchSize        = metrics.ReservedPrefix + "batch_size"
+	telemetryQueuedBytes      = metrics.ReservedPrefix + "queued_bytes"
+	telemetryLastReport       = metrics.ReservedPrefix + "last_report_timestamp"
+)
+
+// telemetryLatencyBuckets are upper bounds of request latencies in
+// milliseconds.
+var telemetryLatencyBuckets = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// telemetry counts what the agent itself does. It is a collector, so the
+// agent reports its own health along with other metrics: counters as deltas
+// since the previous poll, the rest as they are.
+type telemetry struct {
+	mu sync.Mutex
+	// totals are counters since start, collectedTotals holds the part of them
+	// collected already.
+	totals          map[string]metrics.Counter
+	collectedTotals map[string]int64
+	latency         metrics.Histogram
+	newLatency      metrics.Histogram
+	batchSize       int
+	lastReport      time.Time
+
+	queuedBytes func() int64
+}
+
+var _ metrics.DrainingCollector = (*telemetry)(nil)
+
+func newTelemetry(queuedBytes func() int64) *telemetry {
+	return &telemetry{
+		totals:          map[string]metrics.Counter{},
+		collectedTotals: map[string]int64{},
+		latency:         metrics.NewHistogram(telemetryRequestLatency, telemetryLatencyBuckets),
+		newLatency:      metrics.NewHistogram(telemetryRequestLatency, telemetryLatencyBuckets),
+		queuedBytes:     queuedBytes,
+	}
+}
+
+func (t *telemetry) Name() string {
+	return "telemetry"
+}
+
+func (t *telemetry) Drains() {}
+
+func (t *telemetry) Collect(ctx context.Context) (metrics.Collection, error) {
+	// Never take outbox locks while holding ours: delivery records telemetry
+	// on the way out of the outbox.
+	queued := t.queuedBytes()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var result metrics.Collection
+	for key, c := range t.totals {
+		delta := c.Value - t.collectedTotals[key]
+		t.collectedTotals[key] = c.Value
+		result.Counters = append(result.Counters, metrics.Counter{Name: c.Name, Value: delta, Labels: c.Labels})
+	}
+	if t.newLatency.Count > 0 {
+		result.Histograms = append(result.Histograms, t.newLatency)
+		t.newLatency = metrics.NewHistogram(telemetryRequestLatency, telemetryLatencyBuckets)
+	}
+	result.Gauges = t.gaugesLocked(queued)
+	return result, nil
+}
+
+// snapshot returns totals since start.
+func (t *telemetry) snapshot() metrics.Snapshot {
+	queued := t.queuedBytes()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var s metrics.Snapshot
+	for _, c := range t.totals {
+		s.Counters = append(s.Counters, c)
+	}
+	sort.Slice(s.Counters, func(i, j int) bool {
+		return s.Counters[i].SeriesKey() < s.Counters[j].SeriesKey()
+	})
+	s.Gauges = t.gaugesLocked(queued)
+	s.Histograms = []metrics.Histogram{t.latency}
+	return s
+}
+
+func (t *telemetry) gaugesLocked(queuedBytes int64) []metrics.Gauge {
+	gauges := []metrics.Gauge{
+		{Name: telemetryBatchSize, Value: float64(t.batchSize)},
+		{Name: telemetryQueuedBytes, Value: float64(queuedBytes)},
+	}
+	if !t.lastReport.IsZero() {
+		gauges = append(gauges, metrics.Gauge{Name: telemetryLastReport, Value: float64(t.lastReport.Unix())})
+	}
+	return gauges
+}
+
+func (t *telemetry) addLocked(name string, lbls labels.Labels) {
+	key := labels.SeriesKey(name, lbls)
+	c, ok := t.totals[key]
+	if !ok {
+		c = metrics.Counter{Name: name, Labels: lbls}
+	}
+	c.Value++
+	t.totals[key] = c
+}
+
+// collected records a poll and collectors that failed during it.
+func (t *telemetry) collected(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.addLocked(telemetryCollections, nil)
+	var collectorErr *metrics.CollectorError
+	if errors.As(err, &collectorErr) {
+		for name := range collectorErr.Errors {
+			t.addLocked(telemetryCollectionErrors, labels.Labels{"collector": name})
+		}
+	}
+}
+
+// reported records an attempt to send a batch of size metrics to target.
+func (t *telemetry) reported(target string, size int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lbls := labels.Labels{"target": target}
+	if err != nil {
+		t.addLocked(telemetryReportsFailed, lbls)
+		return
+	}
+	t.addLocked(telemetryReportsSent, lbls)
+	t.batchSize = size
+	t.lastReport = time.Now()
+}
+
+func (t *telemetry) observeLatency(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ms := float64(d) / float64(time.Millisecond)
+	t.latency.Observe(ms)
+	t.newLatency.Observe(ms)
+}
+
+// debugServer serves telemetry totals as JSON in the /updates/ format on
+// /debug/telemetry.
+type debugServer struct {
+	listener net.Listener
+	server   *http.Server
+	log      *logging.Logger
+}
+
+func newDebugServer(address string, t *telemetry, log *logging.Logger) (*debugServer, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/telemetry", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		s := t.snapshot()
+		list := make([]handlers.Metrics, 0, len(s.Counters)+len(s.Gauges)+len(s.Histograms))
+		for _, c := range s.Counters {
+			list = append(list, metrics.CounterToHandlerScheme(c))
+		}
+		for _, g := range s.Gauges {
+			list = append(list, metrics.GaugeToHandlerScheme(g))
+		}
+		for _, h := range s.Histograms {
+			list = append(list, metrics.HistogramToHandlerScheme(h))
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(writer).Encode(list); err != nil {
+			log.S().Error(err)
+		}
+	})
+	return &debugServer{listener: listener, server: &http.Server{Handler: mux}, log: log}, nil
+}
+
+func (s *debugServer) serve(ctx context.Context) {
+	serveHTTP(ctx, s.server, s.listener, s.log)
+}
+
+// serveHTTP serves on listener until ctx is done.
+func serveHTTP(ctx context.Context, server *http.Server, listener net.Listener, log *logging.Logger) {
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.S().Errorf("Shutdown of %s: %s", listener.Addr(), err)
+		}
+	}()
+
+	err := server.Serve(listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.S().Errorf("Listener on %s stopped: %s", listener.Addr(), err)
+	}
+}
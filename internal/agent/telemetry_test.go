@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/OmAsana/yapraktikum/internal/handlers"
+	"github.com/OmAsana/yapraktikum/internal/labels"
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+	"github.com/OmAsana/yapraktikum/internal/server"
+)
+
+func TestTelemetry_Collect(t *testing.T) {
+	tel := newTelemetry(func() int64 { return 42 })
+
+	tel.collected(nil)
+	tel.collected(&metrics.CollectorError{Errors: map[string]error{"disk": errors.New("boom")}})
+	tel.reported("primary:8080", 10, nil)
+	tel.reported("primary:8080", 10, errors.New("down"))
+	tel.observeLatency(30 * time.Millisecond)
+
+	collection, err := tel.Collect(context.Background())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []metrics.Counter{
+		{Name: "agent_collections", Value: 2},
+		{Name: "agent_collection_errors", Value: 1, Labels: labels.Labels{"collector": "disk"}},
+		{Name: "agent_reports_sent", Value: 1, Labels: labels.Labels{"target": "primary:8080"}},
+		{Name: "agent_reports_failed", Value: 1, Labels: labels.Labels{"target": "primary:8080"}},
+	}, collection.Counters)
+	require.Len(t, collection.Histograms, 1)
+	assert.Equal(t, uint64(1), collection.Histograms[0].Count)
+
+	gauges := map[string]float64{}
+	for _, g := range collection.Gauges {
+		gauges[g.Name] = g.Value
+	}
+	assert.Equal(t, 10.0, gauges["agent_batch_size"])
+	assert.Equal(t, 42.0, gauges["agent_queued_bytes"])
+	assert.InDelta(t, float64(time.Now().Unix()), gauges["agent_last_report_timestamp"], 5)
+
+	// Counters are deltas since the previous poll.
+	tel.collected(nil)
+	collection, err = tel.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Contains(t, collection.Counters, metrics.Counter{Name: "agent_collections", Value: 1})
+	assert.Contains(t, collection.Counters, metrics.Counter{Name: "agent_collection_errors", Value: 0, Labels: labels.Labels{"collector": "disk"}})
+	assert.Empty(t, collection.Histograms)
+
+	// The debug snapshot keeps totals.
+	snapshot := tel.snapshot()
+	assert.Contains(t, snapshot.Counters, metrics.Counter{Name: "agent_collections", Value: 3})
+	assert.Equal(t, uint64(1), snapshot.Histograms[0].Count)
+}
+
+func TestAgent_Telemetry(t *testing.T) {
+	repo := SetupRepo(t)
+	handler, err := server.NewMetricsServer(repo)
+	require.NoError(t, err)
+	metricServer := httptest.NewServer(handler)
+	defer metricServer.Close()
+
+	agent, err := NewAgentWithOptions(WithAddress(metricServer.URL), WithDebugAddress("127.0.0.1:0"))
+	require.NoError(t, err)
+	require.NoError(t, agent.registry.DisableCollectors("runtime", "memory", "cpu", "disk", "diskio", "net", "load", "uptime"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go agent.debug.serve(ctx)
+
+	for i := 0; i < 2; i++ {
+		agent.telemetry.collected(agent.registry.Collect(ctx))
+		runReports(ctx, agent)
+	}
+
+	// The first report is reported along with the second one.
+	sent, err := repo.RetrieveCounter(context.Background(), "agent_reports_sent", labels.Labels{"target": agent.cfg.BaseURLs[0].Host})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), sent.Value)
+	_, err = repo.RetrieveHistogram(context.Background(), "agent_request_latency_ms", nil)
+	assert.NoError(t, err)
+
+	resp, err := http.Get("http://" + agent.debug.listener.Addr().String() + "/debug/telemetry")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var list []handlers.Metrics
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	values := map[string]handlers.Metrics{}
+	for _, m := range list {
+		values[labels.SeriesKey(m.ID, m.Labels)] = m
+	}
+	assert.Equal(t, int64(2), *values["agent_collections"].Delta)
+	assert.Equal(t, int64(2), *values[labels.SeriesKey("agent_reports_sent", labels.Labels{"target": agent.cfg.BaseURLs[0].Host})].Delta)
+	assert.Equal(t, uint64(2), *values["agent_request_latency_ms"].Count)
+}
+
+func TestAgent_TelemetryDuringReplay(t *testing.T) {
+	sending := make(chan struct{})
+	release := make(chan struct{})
+	var once, releaseOnce sync.Once
+	metricServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			close(sending)
+			<-release
+		})
+	}))
+	defer metricServer.Close()
+	unblock := func() { releaseOnce.Do(func() { close(release) }) }
+	defer unblock()
+
+	agent, err := NewAgentWithOptions(WithAddress(metricServer.URL), WithOutbox(t.TempDir(), 1<<20, time.Hour))
+	require.NoError(t, err)
+	d := agent.destinations[0]
+	require.NoError(t, d.outbox.push(testBatch("queued"), ""))
+
+	delivered := make(chan error)
+	go func() {
+		delivered <- agent.deliver(context.Background(), d, testBatch("new"), "")
+	}()
+	<-sending
+
+	collected := make(chan struct{})
+	go func() {
+		_, err := agent.telemetry.Collect(context.Background())
+		assert.NoError(t, err)
+		agent.telemetry.snapshot()
+		close(collected)
+	}()
+	select {
+	case <-collected:
+	case <-time.After(time.Second):
+		t.Fatal("telemetry is blocked by the outbox replay")
+	}
+
+	unblock()
+	require.NoError(t, <-delivered)
+	queued, err := d.outbox.len()
+	require.NoError(t, err)
+	assert.Zero(t, queued)
+}
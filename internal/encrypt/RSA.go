@@ -0,0 +1,153 @@
+package encrypt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// HeaderEncryption carries HybridScheme on requests whose body is an Envelope.
+const (
+	HeaderEncryption = "X-Encryption"
+	HybridScheme     = "rsa-oaep-sha256+aes-256-gcm"
+)
+
+var (
+	ErrMalformedEnvelope = errors.New("malformed encrypted envelope")
+	ErrDecryption        = errors.New("could not decrypt payload")
+)
+
+// Envelope carries a payload sealed with a random AES-256-GCM key. The key
+// itself is encrypted with the receiver's RSA public key.
+type Envelope struct {
+	Key   []byte `json:"key"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// EncryptHybrid seals data for the owner of pub and returns the JSON encoded
+// envelope. Every call uses a fresh key and nonce.
+func EncryptHybrid(pub *rsa.PublicKey, data []byte) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt session key: %w", err)
+	}
+
+	return json.Marshal(Envelope{
+		Key:   encryptedKey,
+		Nonce: nonce,
+		Data:  gcm.Seal(nil, nonce, data, nil),
+	})
+}
+
+// DecryptHybrid opens an envelope produced by EncryptHybrid.
+func DecryptHybrid(priv *rsa.PrivateKey, payload []byte) ([]byte, error) {
+	var env Envelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedEnvelope, err)
+	}
+	if len(env.Key) == 0 || len(env.Data) == 0 {
+		return nil, fmt.Errorf("%w: key and data are required", ErrMalformedEnvelope)
+	}
+
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, env.Key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: session key: %s", ErrDecryption, err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecryption, err)
+	}
+	if len(env.Nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: invalid nonce size", ErrMalformedEnvelope)
+	}
+
+	data, err := gcm.Open(nil, env.Nonce, env.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecryption, err)
+	}
+	return data, nil
+}
+
+// LoadPublicKey reads a PEM encoded RSA public key in PKIX or PKCS#1 form.
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: not an RSA public key", path)
+		}
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("%s: unexpected PEM block %q", path, block.Type)
+	}
+}
+
+// LoadPrivateKey reads a PEM encoded RSA private key in PKCS#1 or PKCS#8 form.
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		priv, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: not an RSA private key", path)
+		}
+		return priv, nil
+	default:
+		return nil, fmt.Errorf("%s: unexpected PEM block %q", path, block.Type)
+	}
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	return block, nil
+}
@@ -0,0 +1,76 @@
+package encrypt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHybrid(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	msg := []byte(`[{"id":"Alloc","type":"gauge","value":1}]`)
+
+	first, err := EncryptHybrid(&priv.PublicKey, msg)
+	require.NoError(t, err)
+	second, err := EncryptHybrid(&priv.PublicKey, msg)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	decrypted, err := DecryptHybrid(priv, first)
+	require.NoError(t, err)
+	assert.Equal(t, msg, decrypted)
+
+	_, err = DecryptHybrid(priv, msg)
+	assert.ErrorIs(t, err, ErrMalformedEnvelope)
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, err = DecryptHybrid(other, first)
+	assert.ErrorIs(t, err, ErrDecryption)
+}
+
+func TestLoadKeys(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	dir := t.TempDir()
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	pkix, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	require.NoError(t, err)
+
+	write := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+		return path
+	}
+
+	for _, path := range []string{
+		write("pkcs1.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(priv)),
+		write("pkcs8.pem", "PRIVATE KEY", pkcs8),
+	} {
+		got, err := LoadPrivateKey(path)
+		require.NoError(t, err, path)
+		assert.True(t, priv.Equal(got), path)
+	}
+
+	for _, path := range []string{
+		write("pub_pkcs1.pem", "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&priv.PublicKey)),
+		write("pub_pkix.pem", "PUBLIC KEY", pkix),
+	} {
+		got, err := LoadPublicKey(path)
+		require.NoError(t, err, path)
+		assert.True(t, priv.PublicKey.Equal(got), path)
+	}
+
+	_, err = LoadPublicKey(filepath.Join(dir, "pkcs1.pem"))
+	assert.Error(t, err)
+}
@@ -3,16 +3,30 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/OmAsana/yapraktikum/internal/encrypt"
+	"github.com/OmAsana/yapraktikum/internal/labels"
 )
 
+// HeaderIdempotencyKey identifies an update request, so the server applies
+// it once however many times it is retried.
+const HeaderIdempotencyKey = "Idempotency-Key"
+
 type Metrics struct {
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
-	Hash  string   `json:"hash,omitempty"`
+	ID     string        `json:"id"`
+	MType  string        `json:"type"`
+	Delta  *int64        `json:"delta,omitempty"`
+	Value  *float64      `json:"value,omitempty"`
+	Hash   string        `json:"hash,omitempty"`
+	Labels labels.Labels `json:"labels,omitempty"`
+
+	// Histogram fields. Counts are per bucket with the trailing +Inf bucket.
+	Buckets []float64 `json:"buckets,omitempty"`
+	Counts  []uint64  `json:"counts,omitempty"`
+	Sum     *float64  `json:"sum,omitempty"`
+	Count   *uint64   `json:"count,omitempty"`
 }
 
 func (m *Metrics) UnmarshalJSON(bytes []byte) error {
@@ -30,6 +44,11 @@ func (m *Metrics) UnmarshalJSON(bytes []byte) error {
 	if m.ID == "" || m.MType == "" {
 		return fmt.Errorf("missing required fields")
 	}
+
+	if err := m.Labels.Validate(); err != nil {
+		return err
+	}
+	m.Labels = labels.FromMap(m.Labels)
 	return nil
 }
 
@@ -44,15 +63,23 @@ func (m *Metrics) HashMetric(key string) error {
 
 }
 
+// ComputeHash signs the series identity and value. Labels are appended to
+// the ID in canonical form, so unlabeled metrics hash as they always did.
 func (m *Metrics) ComputeHash(key string) (string, error) {
 	var encrypted string
+	id := labels.SeriesKey(m.ID, m.Labels)
 
 	if m.Delta != nil {
-		encrypted = encrypt.EncryptSHA256(fmt.Sprintf("%s:counter:%d", m.ID, *m.Delta), key)
+		encrypted = encrypt.EncryptSHA256(fmt.Sprintf("%s:counter:%d", id, *m.Delta), key)
 	}
 
 	if m.Value != nil {
-		encrypted = encrypt.EncryptSHA256(fmt.Sprintf("%s:gauge:%f", m.ID, *m.Value), key)
+		encrypted = encrypt.EncryptSHA256(fmt.Sprintf("%s:gauge:%f", id, *m.Value), key)
+	}
+
+	if m.MType == "histogram" && m.Sum != nil && m.Count != nil {
+		encrypted = encrypt.EncryptSHA256(fmt.Sprintf("%s:histogram:%s:%s:%f:%d",
+			id, joinFloats(m.Buckets), joinUints(m.Counts), *m.Sum, *m.Count), key)
 	}
 
 	if encrypted == "" {
@@ -60,3 +87,19 @@ func (m *Metrics) ComputeHash(key string) (string, error) {
 	}
 	return encrypted, nil
 }
+
+func joinFloats(values []float64) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+func joinUints(values []uint64) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.FormatUint(v, 10)
+	}
+	return strings.Join(parts, ",")
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/OmAsana/yapraktikum/internal/labels"
 	"github.com/OmAsana/yapraktikum/internal/pkg"
 )
 
@@ -101,3 +102,54 @@ func TestMetrics_ComputeHash(t *testing.T) {
 	require.Equal(t, h1, h2)
 
 }
+
+func TestMetrics_Labels(t *testing.T) {
+	t.Run("decode", func(t *testing.T) {
+		var m Metrics
+		err := json.Unmarshal([]byte(`{"id":"Alloc","type":"gauge","value":1,"labels":{"host":"web-1","env":""}}`), &m)
+		require.NoError(t, err)
+		assert.Equal(t, labels.Labels{"host": "web-1"}, m.Labels)
+	})
+
+	t.Run("invalid label name", func(t *testing.T) {
+		var m Metrics
+		err := json.Unmarshal([]byte(`{"id":"Alloc","type":"gauge","value":1,"labels":{"my-host":"web-1"}}`), &m)
+		require.Error(t, err)
+	})
+
+	t.Run("hash covers labels", func(t *testing.T) {
+		key := "blabla"
+		unlabeled := Metrics{ID: "Alloc", MType: "gauge", Value: pkg.PointerFloat(12)}
+		host1 := Metrics{ID: "Alloc", MType: "gauge", Value: pkg.PointerFloat(12), Labels: labels.Labels{"host": "a"}}
+		host2 := Metrics{ID: "Alloc", MType: "gauge", Value: pkg.PointerFloat(12), Labels: labels.Labels{"host": "b"}}
+
+		h0, err := unlabeled.ComputeHash(key)
+		require.NoError(t, err)
+		h1, err := host1.ComputeHash(key)
+		require.NoError(t, err)
+		h2, err := host2.ComputeHash(key)
+		require.NoError(t, err)
+
+		assert.NotEqual(t, h0, h1)
+		assert.NotEqual(t, h1, h2)
+	})
+}
+
+func TestMetrics_HistogramHash(t *testing.T) {
+	key := "blabla"
+	sum := 1.5
+	count := uint64(2)
+	m := Metrics{ID: "latency", MType: "histogram", Buckets: []float64{1}, Counts: []uint64{1, 1}, Sum: &sum, Count: &count}
+
+	h1, err := m.ComputeHash(key)
+	require.NoError(t, err)
+
+	m.Counts = []uint64{2, 0}
+	h2, err := m.ComputeHash(key)
+	require.NoError(t, err)
+	assert.NotEqual(t, h1, h2)
+
+	m.Sum = nil
+	_, err = m.ComputeHash(key)
+	assert.Error(t, err)
+}
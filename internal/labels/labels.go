@@ -0,0 +1,112 @@
+package labels
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var nameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Labels is an optional set of dimensions attached to a metric series.
+type Labels map[string]string
+
+// FromMap copies m dropping empty values. Returns nil for an empty set so
+// unlabeled series compare equal regardless of how they were built.
+func FromMap(m map[string]string) Labels {
+	var l Labels
+	for k, v := range m {
+		if v == "" {
+			continue
+		}
+		if l == nil {
+			l = make(Labels, len(m))
+		}
+		l[k] = v
+	}
+	return l
+}
+
+// Parse reads labels in the "k1=v1,k2=v2" form used in configs.
+func Parse(s string) (Labels, error) {
+	var l Labels
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid label %q", pair)
+		}
+		if l == nil {
+			l = make(Labels)
+		}
+		l[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	if err := l.Validate(); err != nil {
+		return nil, err
+	}
+	return FromMap(l), nil
+}
+
+func (l Labels) Names() []string {
+	names := make([]string, 0, len(l))
+	for k := range l {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// String returns the canonical form {k1="v1",k2="v2"} with sorted names,
+// or an empty string for an empty set.
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, k := range l.Names() {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(k)
+		sb.WriteString("=")
+		sb.WriteString(strconv.Quote(l[k]))
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+func (l Labels) Validate() error {
+	for k := range l {
+		if !nameRe.MatchString(k) {
+			return fmt.Errorf("invalid label name %q", k)
+		}
+	}
+	return nil
+}
+
+// Merge returns a copy of l overridden by other.
+func (l Labels) Merge(other Labels) Labels {
+	out := make(map[string]string, len(l)+len(other))
+	for k, v := range l {
+		out[k] = v
+	}
+	for k, v := range other {
+		out[k] = v
+	}
+	return FromMap(out)
+}
+
+func (l Labels) Equal(other Labels) bool {
+	return l.String() == other.String()
+}
+
+// SeriesKey identifies a series by its name and label set.
+func SeriesKey(name string, l Labels) string {
+	return name + l.String()
+}
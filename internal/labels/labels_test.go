@@ -0,0 +1,78 @@
+package labels
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Labels
+		wantErr bool
+	}{
+		{name: "empty", input: "", want: nil},
+		{name: "single", input: "host=web-1", want: Labels{"host": "web-1"}},
+		{name: "multiple", input: "host=web-1, env=prod", want: Labels{"host": "web-1", "env": "prod"}},
+		{name: "empty value dropped", input: "host=", want: nil},
+		{name: "missing separator", input: "host", wantErr: true},
+		{name: "invalid name", input: "1host=a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLabels_String(t *testing.T) {
+	assert.Equal(t, "", Labels(nil).String())
+	assert.Equal(t, `{env="prod",host="web-1"}`, Labels{"host": "web-1", "env": "prod"}.String())
+	assert.Equal(t, `Alloc{host="a\"b"}`, SeriesKey("Alloc", Labels{"host": `a"b`}))
+}
+
+func TestLabels_Merge(t *testing.T) {
+	base := Labels{"host": "a", "env": "prod"}
+	got := base.Merge(Labels{"env": "dev", "job": "x"})
+	assert.Equal(t, Labels{"host": "a", "env": "dev", "job": "x"}, got)
+	assert.Equal(t, Labels{"host": "a", "env": "prod"}, base)
+	assert.Nil(t, Labels(nil).Merge(nil))
+}
+
+func TestMatchers(t *testing.T) {
+	l := Labels{"host": "web-1", "env": "prod"}
+	tests := []struct {
+		matcher string
+		want    bool
+	}{
+		{matcher: "host=web-1", want: true},
+		{matcher: "host=web-2", want: false},
+		{matcher: "host!=web-2", want: true},
+		{matcher: "host=~web-.*", want: true},
+		{matcher: "host!~web-.*", want: false},
+		{matcher: "env=~prod|stage", want: true},
+		{matcher: "dc=", want: true},
+		{matcher: "dc!=", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.matcher, func(t *testing.T) {
+			m, err := ParseMatcher(tt.matcher)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, m.Matches(l))
+		})
+	}
+
+	_, err := ParseMatcher("=web")
+	assert.Error(t, err)
+	_, err = ParseMatcher("host=~(")
+	assert.Error(t, err)
+}
@@ -0,0 +1,92 @@
+package labels
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// Matcher selects series by a single label. A missing label matches as an
+// empty value.
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+	re    *regexp.Regexp
+}
+
+func NewMatcher(name string, t MatchType, value string) (Matcher, error) {
+	m := Matcher{Name: name, Type: t, Value: value}
+	switch t {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return Matcher{}, err
+		}
+		m.re = re
+	default:
+		return Matcher{}, fmt.Errorf("unknown match type %q", t)
+	}
+	return m, nil
+}
+
+// ParseMatcher reads a matcher in one of the forms
+// name=value, name!=value, name=~regexp or name!~regexp.
+func ParseMatcher(s string) (Matcher, error) {
+	i := strings.IndexAny(s, "=!")
+	if i <= 0 {
+		return Matcher{}, fmt.Errorf("invalid matcher %q", s)
+	}
+	for _, t := range []MatchType{MatchNotRegexp, MatchRegexp, MatchNotEqual, MatchEqual} {
+		if strings.HasPrefix(s[i:], string(t)) {
+			return NewMatcher(s[:i], t, s[i+len(t):])
+		}
+	}
+	return Matcher{}, fmt.Errorf("invalid matcher %q", s)
+}
+
+func ParseMatchers(ss []string) ([]Matcher, error) {
+	var matchers []Matcher
+	for _, s := range ss {
+		m, err := ParseMatcher(s)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+func (m Matcher) Matches(l Labels) bool {
+	v := l[m.Name]
+	switch m.Type {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(v)
+	case MatchNotRegexp:
+		return !m.re.MatchString(v)
+	}
+	return false
+}
+
+func MatchAll(l Labels, matchers []Matcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(l) {
+			return false
+		}
+	}
+	return true
+}
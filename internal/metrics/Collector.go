@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var DefaultCollectorTimeout = 5 * time.Second
+
+// ReservedPrefix starts names of metrics the agent reports about itself.
+// Metrics from sources outside the agent may not use it.
+const ReservedPrefix = "agent_"
+
+// CheckName rejects names external sources may not use.
+func CheckName(name string) error {
+	if strings.HasPrefix(name, ReservedPrefix) {
+		return fmt.Errorf("metric name prefix %q is reserved", ReservedPrefix)
+	}
+	return nil
+}
+
+// Collection is what a collector read during a single poll. Totals are
+// monotonic values as the system reports them, such as bytes sent over an
+// interface. The registry reports their increments as counter deltas.
+// Counters and Histograms are deltas already and are added as is.
+type Collection struct {
+	Gauges     []Gauge
+	Totals     []Counter
+	Counters   []Counter
+	Histograms []Histogram
+}
+
+// Collector gathers a group of metrics on every poll.
+type Collector interface {
+	Name() string
+	Collect(ctx context.Context) (Collection, error)
+}
+
+// DrainingCollector hands over and resets what it buffered since the previous
+// Collect. A result arriving after the timeout would be lost, so draining
+// collectors run without one and their Collect must not block.
+type DrainingCollector interface {
+	Collector
+	Drains()
+}
+
+type collectorFunc struct {
+	name string
+	fn   func(ctx context.Context) (Collection, error)
+}
+
+func (c collectorFunc) Name() string {
+	return c.name
+}
+
+func (c collectorFunc) Collect(ctx context.Context) (Collection, error) {
+	return c.fn(ctx)
+}
+
+// NewCollector makes a Collector out of a function.
+func NewCollector(name string, fn func(ctx context.Context) (Collection, error)) Collector {
+	return collectorFunc{name: name, fn: fn}
+}
+
+// NewGaugeCollector makes a Collector out of a function reading only gauges.
+func NewGaugeCollector(name string, fn func(ctx context.Context) ([]Gauge, error)) Collector {
+	return NewCollector(name, func(ctx context.Context) (Collection, error) {
+		gauges, err := fn(ctx)
+		return Collection{Gauges: gauges}, err
+	})
+}
+
+// DefaultCollectors returns collectors every registry starts with.
+func DefaultCollectors() []Collector {
+	return []Collector{
+		NewGaugeCollector("runtime", runtimeMemStats),
+		NewGaugeCollector("memory", totalAndFreeMem),
+		NewGaugeCollector("cpu", cpuUtilization),
+		NewGaugeCollector("disk", diskUsage),
+		NewCollector("diskio", diskIO),
+		NewCollector("net", netIO),
+		NewGaugeCollector("load", loadAverage),
+		NewGaugeCollector("uptime", hostUptime),
+	}
+}
+
+// CollectorError reports collectors that failed during a single poll.
+type CollectorError struct {
+	Errors map[string]error
+}
+
+func (e *CollectorError) Error() string {
+	parts := make([]string, 0, len(e.Errors))
+	for name, err := range e.Errors {
+		parts = append(parts, fmt.Sprintf("collector %s: %s", name, err))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// runCollectors runs collectors concurrently, each with its own timeout
+// unless it is a DrainingCollector. Collections of collectors that succeeded are merged in collector order even
+// when others fail.
+func runCollectors(ctx context.Context, collectors []Collector, timeout time.Duration) (Collection, error) {
+	type result struct {
+		collection Collection
+		err        error
+	}
+
+	results := make([]chan result, len(collectors))
+	for i, c := range collectors {
+		results[i] = make(chan result, 1)
+		go func(c Collector, out chan<- result) {
+			if _, ok := c.(DrainingCollector); ok {
+				collection, err := c.Collect(ctx)
+				out <- result{collection: collection, err: err}
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			// Collectors that ignore ctx are abandoned once it expires.
+			done := make(chan result, 1)
+			go func() {
+				collection, err := c.Collect(ctx)
+				done <- result{collection: collection, err: err}
+			}()
+
+			select {
+			case r := <-done:
+				out <- r
+			case <-ctx.Done():
+				out <- result{err: ctx.Err()}
+			}
+		}(c, results[i])
+	}
+
+	var merged Collection
+	failed := map[string]error{}
+	for i, c := range collectors {
+		r := <-results[i]
+		if r.err != nil {
+			failed[c.Name()] = r.err
+			continue
+		}
+		merged.Gauges = append(merged.Gauges, r.collection.Gauges...)
+		merged.Totals = append(merged.Totals, r.collection.Totals...)
+		merged.Counters = append(merged.Counters, r.collection.Counters...)
+		merged.Histograms = append(merged.Histograms, r.collection.Histograms...)
+	}
+
+	if len(failed) > 0 {
+		return merged, &CollectorError{Errors: failed}
+	}
+	return merged, nil
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func staticCollector(name string, gauges ...Gauge) Collector {
+	return NewGaugeCollector(name, func(ctx context.Context) ([]Gauge, error) {
+		return gauges, nil
+	})
+}
+
+// slowDrainingCollector takes longer than the collector timeout.
+type slowDrainingCollector struct {
+	delay time.Duration
+}
+
+func (c slowDrainingCollector) Name() string {
+	return "draining"
+}
+
+func (c slowDrainingCollector) Drains() {}
+
+func (c slowDrainingCollector) Collect(ctx context.Context) (Collection, error) {
+	time.Sleep(c.delay)
+	return Collection{Counters: []Counter{{Name: "Drained", Value: 3}}}, nil
+}
+
+func TestRegistry_Collectors(t *testing.T) {
+	failing := NewGaugeCollector("failing", func(ctx context.Context) ([]Gauge, error) {
+		return nil, errors.New("boom")
+	})
+	slow := NewGaugeCollector("slow", func(ctx context.Context) ([]Gauge, error) {
+		time.Sleep(time.Second)
+		return []Gauge{{Name: "Slow"}}, nil
+	})
+
+	r := NewRegistry()
+	r.collectors = nil
+	r.AddCollector(staticCollector("first", Gauge{Name: "A", Value: 1}))
+	r.AddCollector(failing)
+	r.AddCollector(slow)
+	r.AddCollector(staticCollector("second", Gauge{Name: "B", Value: 2}))
+	r.SetCollectorTimeout(50 * time.Millisecond)
+
+	start := time.Now()
+	err := r.Collect(context.Background())
+	assert.Less(t, time.Since(start), time.Second)
+
+	var collectorErr *CollectorError
+	require.ErrorAs(t, err, &collectorErr)
+	assert.Len(t, collectorErr.Errors, 2)
+	assert.ErrorIs(t, collectorErr.Errors["slow"], context.DeadlineExceeded)
+
+	var names []string
+	for _, g := range r.Gauges {
+		names = append(names, g.Name)
+	}
+	assert.Equal(t, []string{"A", "B", "RandomValue"}, names)
+	assert.Equal(t, int64(1), r.PollCounter.Value)
+
+	require.NoError(t, r.DisableCollectors("failing", "slow"))
+	require.NoError(t, r.Collect(context.Background()))
+	assert.Error(t, r.DisableCollectors("missing"))
+}
+
+func TestRunCollectors_Draining(t *testing.T) {
+	collection, err := runCollectors(context.Background(), []Collector{
+		slowDrainingCollector{delay: 100 * time.Millisecond},
+	}, 10*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, []Counter{{Name: "Drained", Value: 3}}, collection.Counters)
+}
@@ -4,15 +4,21 @@ import (
 	"fmt"
 
 	"github.com/OmAsana/yapraktikum/internal/handlers"
+	"github.com/OmAsana/yapraktikum/internal/labels"
 )
 
 type Counter struct {
-	Name  string
-	Value int64
+	Name   string
+	Value  int64
+	Labels labels.Labels
 }
 
 func (c Counter) String() string {
-	return fmt.Sprintf("<Counter: Name: %s, Value: %d>", c.Name, c.Value)
+	return fmt.Sprintf("<Counter: Name: %s%s, Value: %d>", c.Name, c.Labels, c.Value)
+}
+
+func (c Counter) SeriesKey() string {
+	return labels.SeriesKey(c.Name, c.Labels)
 }
 
 func (c Counter) IsValid() error {
@@ -24,16 +30,18 @@ func (c Counter) IsValid() error {
 
 func CounterToHandlerScheme(c Counter) handlers.Metrics {
 	return handlers.Metrics{
-		ID:    c.Name,
-		MType: "counter",
-		Delta: &c.Value,
-		Value: nil,
+		ID:     c.Name,
+		MType:  "counter",
+		Delta:  &c.Value,
+		Value:  nil,
+		Labels: c.Labels,
 	}
 }
 
 func CounterFromHandler(h handlers.Metrics) Counter {
 	return Counter{
-		Name:  h.ID,
-		Value: *h.Delta,
+		Name:   h.ID,
+		Value:  *h.Delta,
+		Labels: labels.FromMap(h.Labels),
 	}
 }
@@ -5,11 +5,13 @@ import (
 	"fmt"
 
 	"github.com/OmAsana/yapraktikum/internal/handlers"
+	"github.com/OmAsana/yapraktikum/internal/labels"
 )
 
 type Gauge struct {
-	Name  string  `json:"name"`
-	Value float64 `json:"value"`
+	Name   string        `json:"name"`
+	Value  float64       `json:"value"`
+	Labels labels.Labels `json:"labels,omitempty"`
 }
 
 func (c *Gauge) MarshalJSON() ([]byte, error) {
@@ -24,21 +26,27 @@ func (c *Gauge) MarshalJSON() ([]byte, error) {
 }
 
 func (c Gauge) String() string {
-	return fmt.Sprintf("<Gauge: Name: %s, Value: %f>", c.Name, c.Value)
+	return fmt.Sprintf("<Gauge: Name: %s%s, Value: %f>", c.Name, c.Labels, c.Value)
+}
+
+func (c Gauge) SeriesKey() string {
+	return labels.SeriesKey(c.Name, c.Labels)
 }
 
 func GaugeToHandlerScheme(g Gauge) handlers.Metrics {
 	return handlers.Metrics{
-		ID:    g.Name,
-		MType: "gauge",
-		Delta: nil,
-		Value: &g.Value,
+		ID:     g.Name,
+		MType:  "gauge",
+		Delta:  nil,
+		Value:  &g.Value,
+		Labels: g.Labels,
 	}
 }
 
 func GaugeFromHandler(g handlers.Metrics) Gauge {
 	return Gauge{
-		Name:  g.ID,
-		Value: *g.Value,
+		Name:   g.ID,
+		Value:  *g.Value,
+		Labels: labels.FromMap(g.Labels),
 	}
 }
@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/OmAsana/yapraktikum/internal/handlers"
+	"github.com/OmAsana/yapraktikum/internal/labels"
+	"github.com/OmAsana/yapraktikum/internal/pkg"
+)
+
+var ErrHistogramBucketsMismatch = fmt.Errorf("histogram buckets do not match")
+
+// Histogram is a distribution of observations. Buckets holds sorted upper
+// bounds, the +Inf bucket is implicit. Counts are per bucket, not cumulative,
+// and have one more element than Buckets for the +Inf bucket.
+type Histogram struct {
+	Name    string
+	Buckets []float64
+	Counts  []uint64
+	Sum     float64
+	Count   uint64
+	Labels  labels.Labels
+}
+
+func NewHistogram(name string, buckets []float64) Histogram {
+	b := make([]float64, len(buckets))
+	copy(b, buckets)
+	sort.Float64s(b)
+	return Histogram{
+		Name:    name,
+		Buckets: b,
+		Counts:  make([]uint64, len(b)+1),
+	}
+}
+
+func (h Histogram) String() string {
+	return fmt.Sprintf("<Histogram: Name: %s%s, Count: %d, Sum: %f>", h.Name, h.Labels, h.Count, h.Sum)
+}
+
+func (h Histogram) SeriesKey() string {
+	return labels.SeriesKey(h.Name, h.Labels)
+}
+
+func (h Histogram) IsValid() error {
+	for i, b := range h.Buckets {
+		if !pkg.FloatIsNumber(b) {
+			return fmt.Errorf("bucket bound must be a number")
+		}
+		if i > 0 && b <= h.Buckets[i-1] {
+			return fmt.Errorf("buckets must be sorted and unique")
+		}
+	}
+	if len(h.Counts) != len(h.Buckets)+1 {
+		return fmt.Errorf("counts must have one element per bucket plus +Inf")
+	}
+	if !pkg.FloatIsNumber(h.Sum) {
+		return fmt.Errorf("sum must be a number")
+	}
+
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	if total != h.Count {
+		return fmt.Errorf("count does not match bucket counts")
+	}
+	return nil
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.ObserveN(v, 1)
+}
+
+// ObserveN records n observations of v at once.
+func (h *Histogram) ObserveN(v float64, n uint64) {
+	i := sort.SearchFloat64s(h.Buckets, v)
+	h.Counts[i] += n
+	h.Sum += v * float64(n)
+	h.Count += n
+}
+
+func (h Histogram) SameBuckets(other Histogram) bool {
+	if len(h.Buckets) != len(other.Buckets) {
+		return false
+	}
+	for i := range h.Buckets {
+		if h.Buckets[i] != other.Buckets[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge adds observations of other. Both histograms must share buckets.
+func (h *Histogram) Merge(other Histogram) error {
+	if !h.SameBuckets(other) {
+		return ErrHistogramBucketsMismatch
+	}
+	counts := make([]uint64, len(h.Counts))
+	for i := range h.Counts {
+		counts[i] = h.Counts[i] + other.Counts[i]
+	}
+	h.Counts = counts
+	h.Sum += other.Sum
+	h.Count += other.Count
+	return nil
+}
+
+// Cumulative returns counts of observations less or equal to every bucket
+// bound, the last element being the +Inf bucket.
+func (h Histogram) Cumulative() []uint64 {
+	result := make([]uint64, len(h.Counts))
+	var total uint64
+	for i, c := range h.Counts {
+		total += c
+		result[i] = total
+	}
+	return result
+}
+
+func HistogramToHandlerScheme(h Histogram) handlers.Metrics {
+	sum := h.Sum
+	count := h.Count
+	return handlers.Metrics{
+		ID:      h.Name,
+		MType:   "histogram",
+		Buckets: h.Buckets,
+		Counts:  h.Counts,
+		Sum:     &sum,
+		Count:   &count,
+		Labels:  h.Labels,
+	}
+}
+
+func HistogramFromHandler(m handlers.Metrics) Histogram {
+	h := Histogram{
+		Name:    m.ID,
+		Buckets: m.Buckets,
+		Counts:  m.Counts,
+		Labels:  labels.FromMap(m.Labels),
+	}
+	if m.Sum != nil {
+		h.Sum = *m.Sum
+	}
+	if m.Count != nil {
+		h.Count = *m.Count
+	}
+	return h
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram_Observe(t *testing.T) {
+	h := NewHistogram("latency", []float64{1, 0.1, 0.5})
+	assert.Equal(t, []float64{0.1, 0.5, 1}, h.Buckets)
+
+	for _, v := range []float64{0.05, 0.1, 0.3, 0.7, 5} {
+		h.Observe(v)
+	}
+	assert.Equal(t, []uint64{2, 1, 1, 1}, h.Counts)
+	assert.Equal(t, []uint64{2, 3, 4, 5}, h.Cumulative())
+	assert.Equal(t, uint64(5), h.Count)
+	assert.InDelta(t, 6.15, h.Sum, 1e-9)
+	assert.NoError(t, h.IsValid())
+}
+
+func TestHistogram_ObserveN(t *testing.T) {
+	h := NewHistogram("latency", []float64{1})
+	h.ObserveN(0.5, 1000)
+	h.ObserveN(2, 0)
+	assert.Equal(t, []uint64{1000, 0}, h.Counts)
+	assert.Equal(t, uint64(1000), h.Count)
+	assert.Equal(t, 500.0, h.Sum)
+}
+
+func TestHistogram_Merge(t *testing.T) {
+	h := NewHistogram("latency", []float64{1, 2})
+	h.Observe(1)
+
+	other := NewHistogram("latency", []float64{1, 2})
+	other.Observe(3)
+	require.NoError(t, h.Merge(other))
+	assert.Equal(t, []uint64{1, 0, 1}, h.Counts)
+	assert.Equal(t, uint64(2), h.Count)
+	assert.Equal(t, 4.0, h.Sum)
+
+	assert.ErrorIs(t, h.Merge(NewHistogram("latency", []float64{1})), ErrHistogramBucketsMismatch)
+}
+
+func TestHistogram_IsValid(t *testing.T) {
+	tests := []struct {
+		name string
+		h    Histogram
+	}{
+		{
+			name: "unsorted buckets",
+			h:    Histogram{Name: "h", Buckets: []float64{2, 1}, Counts: []uint64{0, 0, 0}},
+		},
+		{
+			name: "counts length",
+			h:    Histogram{Name: "h", Buckets: []float64{1}, Counts: []uint64{0}},
+		},
+		{
+			name: "count mismatch",
+			h:    Histogram{Name: "h", Buckets: []float64{1}, Counts: []uint64{1, 1}, Count: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, tt.h.IsValid())
+		})
+	}
+}
+
+func TestRegistry_GCPauses(t *testing.T) {
+	stats := &runtime.MemStats{NumGC: 258}
+	stats.PauseNs[0] = 2
+	stats.PauseNs[1] = 3
+	stats.PauseNs[255] = 1
+	assert.Equal(t, []uint64{1, 2, 3}, recentGCPauses(stats, 255))
+	assert.Len(t, recentGCPauses(stats, 0), len(stats.PauseNs))
+
+	r := NewRegistry()
+	runtime.GC()
+	r.observeGCPauses()
+	require.Len(t, r.Histograms, 1)
+	assert.Equal(t, "GCPauseNs", r.Histograms[0].Name)
+	assert.NotZero(t, r.Histograms[0].Count)
+
+	r.Commit(r.Export())
+	assert.Zero(t, r.Histograms[0].Count)
+}
@@ -2,35 +2,101 @@ package metrics
 
 import (
 	"context"
+	"fmt"
 	"math/rand"
+	"runtime"
 	"sync"
+	"time"
 )
 
+// gcPauseBuckets are upper bounds of GC pause durations in nanoseconds.
+var gcPauseBuckets = []float64{1e4, 5e4, 1e5, 5e5, 1e6, 5e6, 1e7, 5e7, 1e8}
+
 type Registry struct {
 	sync.RWMutex
 	Gauges      []Gauge
 	Counters    []Counter
+	Histograms  []Histogram
 	PollCounter Counter
+
+	lastNumGC        uint32
+	totals           map[string]int64
+	collectors       []Collector
+	collectorTimeout time.Duration
 }
 
 func NewRegistry() *Registry {
-	return &Registry{PollCounter: Counter{
-		Name:  "PollCount",
-		Value: 0,
-	}}
+	return &Registry{
+		PollCounter: Counter{
+			Name:  "PollCount",
+			Value: 0,
+		},
+		totals:           map[string]int64{},
+		collectors:       DefaultCollectors(),
+		collectorTimeout: DefaultCollectorTimeout,
+	}
 }
 
-func (r *Registry) Collect(ctx context.Context) error {
+// AddCollector registers c to run on every Collect.
+func (r *Registry) AddCollector(c Collector) {
 	r.Lock()
 	defer r.Unlock()
+	r.collectors = append(r.collectors, c)
+}
 
-	var err error
-	r.Gauges, err = CollectRuntimeMetrics(ctx)
-	if err != nil {
-		return err
+// DisableCollectors unregisters collectors by name. Unknown names are an
+// error, so a typo in the config does not go unnoticed.
+func (r *Registry) DisableCollectors(names ...string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, name := range names {
+		found := false
+		for i, c := range r.collectors {
+			if c.Name() == name {
+				r.collectors = append(r.collectors[:i:i], r.collectors[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown collector %q", name)
+		}
 	}
+	return nil
+}
+
+// SetCollectorTimeout limits how long a single collector may run.
+func (r *Registry) SetCollectorTimeout(timeout time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	r.collectorTimeout = timeout
+}
+
+// Collect runs all collectors and replaces gauges with their results. Gauges
+// of a failed collector are dropped while the others are kept, the returned
+// error is a *CollectorError then.
+func (r *Registry) Collect(ctx context.Context) error {
+	r.RLock()
+	collectors := r.collectors
+	timeout := r.collectorTimeout
+	r.RUnlock()
+
+	collection, err := runCollectors(ctx, collectors, timeout)
+
+	r.Lock()
+	defer r.Unlock()
 
-	r.Gauges = append(r.Gauges, func() Gauge {
+	r.addTotals(collection.Totals)
+	for _, c := range collection.Counters {
+		r.addCounter(c)
+	}
+	for _, h := range collection.Histograms {
+		if mergeErr := r.mergeHistogram(h); mergeErr != nil && err == nil {
+			err = mergeErr
+		}
+	}
+	r.Gauges = append(collection.Gauges, func() Gauge {
 		return Gauge{
 			Name:  "RandomValue",
 			Value: rand.Float64(),
@@ -38,14 +104,212 @@ func (r *Registry) Collect(ctx context.Context) error {
 	}())
 	r.PollCounter.Value += 1
 
+	r.observeGCPauses()
+
 	return err
 }
 
-func (r *Registry) Export() ([]Gauge, []Counter) {
+// addTotals adds increments of monotonic totals since the previous poll to
+// counters. The first reading of a series only sets the baseline, and a
+// total that went down means its source was reset.
+// Must be called with the lock held.
+func (r *Registry) addTotals(totals []Counter) {
+	for _, total := range totals {
+		key := total.SeriesKey()
+		last, seen := r.totals[key]
+		r.totals[key] = total.Value
+
+		delta := total.Value - last
+		switch {
+		case !seen:
+			delta = 0
+		case delta < 0:
+			delta = total.Value
+		}
+		r.addCounter(Counter{Name: total.Name, Value: delta, Labels: total.Labels})
+	}
+}
+
+// mergeHistogram adds observations of h to the histogram of the same series.
+// Must be called with the lock held.
+func (r *Registry) mergeHistogram(h Histogram) error {
+	for i := range r.Histograms {
+		if r.Histograms[i].SeriesKey() == h.SeriesKey() {
+			if err := r.Histograms[i].Merge(h); err != nil {
+				return fmt.Errorf("histogram %s: %w", h.SeriesKey(), err)
+			}
+			return nil
+		}
+	}
+	h.Counts = append([]uint64(nil), h.Counts...)
+	r.Histograms = append(r.Histograms, h)
+	return nil
+}
+
+// observeGCPauses records pauses of collections finished since the previous
+// call into the GCPauseNs histogram.
+func (r *Registry) observeGCPauses() {
+	stats := new(runtime.MemStats)
+	runtime.ReadMemStats(stats)
+
+	h := r.histogram("GCPauseNs", gcPauseBuckets)
+	for _, pause := range recentGCPauses(stats, r.lastNumGC) {
+		h.Observe(float64(pause))
+	}
+	r.lastNumGC = stats.NumGC
+}
+
+// recentGCPauses returns pauses of collections after lastNumGC. PauseNs is a
+// circular buffer, so at most len(PauseNs) pauses are available.
+func recentGCPauses(stats *runtime.MemStats, lastNumGC uint32) []uint64 {
+	n := stats.NumGC - lastNumGC
+	if n > uint32(len(stats.PauseNs)) {
+		n = uint32(len(stats.PauseNs))
+	}
+
+	pauses := make([]uint64, 0, n)
+	for gc := stats.NumGC - n; gc < stats.NumGC; gc++ {
+		pauses = append(pauses, stats.PauseNs[gc%uint32(len(stats.PauseNs))])
+	}
+	return pauses
+}
+
+// histogram returns the registered histogram with name, creating it if needed.
+// Must be called with the lock held.
+func (r *Registry) histogram(name string, buckets []float64) *Histogram {
+	for i := range r.Histograms {
+		if r.Histograms[i].Name == name {
+			return &r.Histograms[i]
+		}
+	}
+	r.Histograms = append(r.Histograms, NewHistogram(name, buckets))
+	return &r.Histograms[len(r.Histograms)-1]
+}
+
+// Snapshot is a copy of registry metrics taken for a single report. Counter
+// values and histogram observations in it are deltas since the last commit.
+type Snapshot struct {
+	Gauges     []Gauge
+	Counters   []Counter
+	Histograms []Histogram
+}
+
+// AddCounter adds delta to the counter series of c, registering it if needed.
+func (r *Registry) AddCounter(c Counter) {
+	r.Lock()
+	defer r.Unlock()
+	r.addCounter(c)
+}
+
+func (r *Registry) addCounter(c Counter) {
+	for i := range r.Counters {
+		if r.Counters[i].SeriesKey() == c.SeriesKey() {
+			r.Counters[i].Value += c.Value
+			return
+		}
+	}
+	r.Counters = append(r.Counters, c)
+}
+
+// Export returns metrics collected since the last commit. PollCounter is
+// exported along with the other counters.
+func (r *Registry) Export() Snapshot {
 	r.RLock()
 	defer r.RUnlock()
 
-	counter := r.Counters
-	counter = append(counter, r.PollCounter)
-	return r.Gauges, counter
+	gauges := make([]Gauge, len(r.Gauges))
+	copy(gauges, r.Gauges)
+
+	counters := make([]Counter, 0, len(r.Counters)+1)
+	counters = append(counters, r.Counters...)
+	counters = append(counters, r.PollCounter)
+
+	histograms := make([]Histogram, 0, len(r.Histograms))
+	for _, h := range r.Histograms {
+		if h.Count == 0 {
+			continue
+		}
+		exported := h
+		exported.Counts = append([]uint64(nil), h.Counts...)
+		histograms = append(histograms, exported)
+	}
+	return Snapshot{Gauges: gauges, Counters: counters, Histograms: histograms}
+}
+
+// Commit subtracts a delivered snapshot from the registry, so that only
+// deltas collected after Export are reported next time. Counters keep their
+// series, a counter with nothing new to report has a zero value.
+func (r *Registry) Commit(s Snapshot) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, sent := range s.Counters {
+		if sent.SeriesKey() == r.PollCounter.SeriesKey() {
+			r.PollCounter.Value -= sent.Value
+			continue
+		}
+		for i := range r.Counters {
+			if r.Counters[i].SeriesKey() == sent.SeriesKey() {
+				r.Counters[i].Value -= sent.Value
+			}
+		}
+	}
+
+	for _, sent := range s.Histograms {
+		for i := range r.Histograms {
+			h := &r.Histograms[i]
+			if h.SeriesKey() != sent.SeriesKey() || !h.SameBuckets(sent) {
+				continue
+			}
+			for j := range h.Counts {
+				h.Counts[j] -= sent.Counts[j]
+			}
+			h.Sum -= sent.Sum
+			h.Count -= sent.Count
+		}
+	}
+}
+
+// Merge adds a later snapshot to s: gauges take the later value, counter
+// deltas and histogram observations add up. A histogram whose buckets
+// changed in between keeps only the later observations.
+func (s *Snapshot) Merge(later Snapshot) {
+	gauges := make(map[string]int, len(s.Gauges))
+	for i, g := range s.Gauges {
+		gauges[g.SeriesKey()] = i
+	}
+	for _, g := range later.Gauges {
+		if i, ok := gauges[g.SeriesKey()]; ok {
+			s.Gauges[i] = g
+			continue
+		}
+		s.Gauges = append(s.Gauges, g)
+	}
+
+	counters := make(map[string]int, len(s.Counters))
+	for i, c := range s.Counters {
+		counters[c.SeriesKey()] = i
+	}
+	for _, c := range later.Counters {
+		if i, ok := counters[c.SeriesKey()]; ok {
+			s.Counters[i].Value += c.Value
+			continue
+		}
+		s.Counters = append(s.Counters, c)
+	}
+
+	histograms := make(map[string]int, len(s.Histograms))
+	for i, h := range s.Histograms {
+		histograms[h.SeriesKey()] = i
+	}
+	for _, h := range later.Histograms {
+		i, ok := histograms[h.SeriesKey()]
+		if !ok {
+			s.Histograms = append(s.Histograms, h)
+			continue
+		}
+		if err := s.Histograms[i].Merge(h); err != nil {
+			s.Histograms[i] = h
+		}
+	}
 }
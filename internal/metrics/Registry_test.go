@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/OmAsana/yapraktikum/internal/labels"
+)
+
+func TestRegistry_Commit(t *testing.T) {
+	r := NewRegistry()
+	r.collectors = nil
+	r.AddCounter(Counter{Name: "requests", Value: 2})
+	r.AddCounter(Counter{Name: "requests", Value: 3, Labels: labels.Labels{"code": "500"}})
+	r.AddCounter(Counter{Name: "requests", Value: 1})
+	require.NoError(t, r.Collect(context.Background()))
+
+	snapshot := r.Export()
+	assert.ElementsMatch(t, []Counter{
+		{Name: "requests", Value: 3},
+		{Name: "requests", Value: 3, Labels: labels.Labels{"code": "500"}},
+		{Name: "PollCount", Value: 1},
+	}, snapshot.Counters)
+
+	// Increments between export and commit survive the commit.
+	r.AddCounter(Counter{Name: "requests", Value: 4})
+	require.NoError(t, r.Collect(context.Background()))
+	r.Commit(snapshot)
+
+	assert.ElementsMatch(t, []Counter{
+		{Name: "requests", Value: 4},
+		{Name: "requests", Value: 0, Labels: labels.Labels{"code": "500"}},
+		{Name: "PollCount", Value: 1},
+	}, r.Export().Counters)
+}
+
+func TestRegistry_ExportIsACopy(t *testing.T) {
+	r := NewRegistry()
+	r.Counters = make([]Counter, 1, 4)
+	r.Counters[0] = Counter{Name: "requests", Value: 1}
+
+	snapshot := r.Export()
+	r.AddCounter(Counter{Name: "other", Value: 1})
+	snapshot.Counters[0].Value = 100
+
+	assert.Equal(t, int64(1), r.Counters[0].Value)
+	assert.Equal(t, "PollCount", snapshot.Counters[1].Name)
+}
+
+func TestSnapshot_Merge(t *testing.T) {
+	latency := NewHistogram("latency", []float64{10})
+	latency.Observe(5)
+	s := Snapshot{
+		Gauges:     []Gauge{{Name: "Alloc", Value: 1}},
+		Counters:   []Counter{{Name: "PollCount", Value: 2}},
+		Histograms: []Histogram{latency},
+	}
+
+	later := NewHistogram("latency", []float64{10})
+	later.Observe(20)
+	rebucketed := NewHistogram("other", []float64{1})
+	rebucketed.Observe(1)
+	s.Merge(Snapshot{
+		Gauges:     []Gauge{{Name: "Alloc", Value: 3}, {Name: "Free", Value: 4}},
+		Counters:   []Counter{{Name: "PollCount", Value: 5}, {Name: "PollCount", Value: 1, Labels: labels.Labels{"a": "b"}}},
+		Histograms: []Histogram{later, rebucketed},
+	})
+	s.Merge(Snapshot{Histograms: []Histogram{NewHistogram("other", []float64{2})}})
+
+	assert.Equal(t, []Gauge{{Name: "Alloc", Value: 3}, {Name: "Free", Value: 4}}, s.Gauges)
+	assert.Equal(t, []Counter{{Name: "PollCount", Value: 7}, {Name: "PollCount", Value: 1, Labels: labels.Labels{"a": "b"}}}, s.Counters)
+	require.Len(t, s.Histograms, 2)
+	assert.Equal(t, uint64(2), s.Histograms[0].Count)
+	assert.Equal(t, 25.0, s.Histograms[0].Sum)
+	assert.Equal(t, []float64{2}, s.Histograms[1].Buckets)
+	assert.Zero(t, s.Histograms[1].Count)
+}
@@ -0,0 +1,33 @@
+package metrics
+
+import "time"
+
+// Sample is a single timestamped value of a series. Counters are recorded
+// with their cumulative value after the write.
+type Sample struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// Downsample keeps the last sample of every step wide bucket starting at
+// from. The sample is reported at its bucket start. Samples must be sorted by
+// timestamp.
+func Downsample(samples []Sample, from time.Time, step time.Duration) []Sample {
+	if step <= 0 {
+		return samples
+	}
+
+	var result []Sample
+	for _, s := range samples {
+		if s.Timestamp.Before(from) {
+			continue
+		}
+		bucket := from.Add(s.Timestamp.Sub(from) / step * step)
+		if n := len(result); n > 0 && result[n-1].Timestamp.Equal(bucket) {
+			result[n-1].Value = s.Value
+			continue
+		}
+		result = append(result, Sample{Timestamp: bucket, Value: s.Value})
+	}
+	return result
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownsample(t *testing.T) {
+	from := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(sec int) time.Time {
+		return from.Add(time.Duration(sec) * time.Second)
+	}
+	samples := []Sample{
+		{Timestamp: at(-1), Value: 0},
+		{Timestamp: at(1), Value: 1},
+		{Timestamp: at(4), Value: 2},
+		{Timestamp: at(11), Value: 3},
+		{Timestamp: at(35), Value: 4},
+	}
+
+	t.Run("no step", func(t *testing.T) {
+		assert.Equal(t, samples, Downsample(samples, from, 0))
+	})
+
+	t.Run("10s step", func(t *testing.T) {
+		want := []Sample{
+			{Timestamp: at(0), Value: 2},
+			{Timestamp: at(10), Value: 3},
+			{Timestamp: at(30), Value: 4},
+		}
+		assert.Equal(t, want, Downsample(samples, from, 10*time.Second))
+	})
+}
@@ -0,0 +1,238 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OmAsana/yapraktikum/internal/handlers"
+	"github.com/OmAsana/yapraktikum/internal/labels"
+	"github.com/OmAsana/yapraktikum/internal/pkg"
+)
+
+var DefaultPluginTimeout = 3 * time.Second
+
+// Counters the exec collector reports about its plugins.
+const (
+	pluginErrors   = ReservedPrefix + "plugin_errors"
+	pluginTimeouts = ReservedPrefix + "plugin_timeouts"
+)
+
+// ExecCommand is an external program whose stdout is parsed into metrics.
+type ExecCommand struct {
+	Name string
+	Path string
+	Args []string
+}
+
+// ParseExecCommands parses semicolon separated command lines. Every plugin is
+// named after its executable.
+func ParseExecCommands(s string) []ExecCommand {
+	var commands []ExecCommand
+	for _, line := range strings.Split(s, ";") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		commands = append(commands, ExecCommand{
+			Name: filepath.Base(fields[0]),
+			Path: fields[0],
+			Args: fields[1:],
+		})
+	}
+	return commands
+}
+
+// ExecCollector runs plugins on every poll. A plugin prints either lines of
+// "name type value [labels]", where labels look like "k=v,k2=v2", or a JSON
+// array of handlers.Metrics. Counter values are deltas.
+//
+// Failures of a plugin never fail the collector, they are reported in the
+// agent_plugin_errors and agent_plugin_timeouts counters labeled by plugin
+// name.
+type ExecCollector struct {
+	commands []ExecCommand
+	timeout  time.Duration
+}
+
+func NewExecCollector(commands []ExecCommand, timeout time.Duration) *ExecCollector {
+	return &ExecCollector{commands: commands, timeout: timeout}
+}
+
+func (c *ExecCollector) Name() string {
+	return "exec"
+}
+
+func (c *ExecCollector) Collect(ctx context.Context) (Collection, error) {
+	results := make([]Collection, len(c.commands))
+	wg := sync.WaitGroup{}
+	for i, cmd := range c.commands {
+		wg.Add(1)
+		go func(i int, cmd ExecCommand) {
+			defer wg.Done()
+			results[i] = c.run(ctx, cmd)
+		}(i, cmd)
+	}
+	wg.Wait()
+
+	var merged Collection
+	for _, r := range results {
+		merged.Gauges = append(merged.Gauges, r.Gauges...)
+		merged.Counters = append(merged.Counters, r.Counters...)
+	}
+	return merged, nil
+}
+
+func (c *ExecCollector) run(ctx context.Context, cmd ExecCommand) Collection {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var errCount, timeoutCount int64
+	out, err := runPlugin(ctx, cmd)
+	var result Collection
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		timeoutCount = 1
+	case err != nil:
+		errCount = 1
+	default:
+		result, err = parsePluginOutput(out)
+		if err != nil {
+			errCount = 1
+		}
+	}
+
+	lbls := labels.Labels{"plugin": cmd.Name}
+	result.Counters = append(result.Counters,
+		Counter{Name: pluginErrors, Value: errCount, Labels: lbls},
+		Counter{Name: pluginTimeouts, Value: timeoutCount, Labels: lbls},
+	)
+	return result
+}
+
+// runPlugin returns stdout of the plugin. When ctx is done, the whole process
+// group of the plugin is killed, so children of a shell script holding stdout
+// open cannot keep it running past the timeout.
+func runPlugin(ctx context.Context, command ExecCommand) ([]byte, error) {
+	cmd := exec.Command(command.Path, command.Args...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return stdout.Bytes(), err
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		<-done
+		return nil, ctx.Err()
+	}
+}
+
+// parsePluginOutput accepts either output format. Output is rejected as a
+// whole if any metric in it is invalid.
+func parsePluginOutput(out []byte) (Collection, error) {
+	out = bytes.TrimSpace(out)
+	if bytes.HasPrefix(out, []byte("[")) {
+		return parsePluginJSON(out)
+	}
+	return parsePluginLines(out)
+}
+
+func parsePluginJSON(out []byte) (Collection, error) {
+	var list []handlers.Metrics
+	if err := json.Unmarshal(out, &list); err != nil {
+		return Collection{}, err
+	}
+
+	var result Collection
+	for _, m := range list {
+		if err := CheckName(m.ID); err != nil {
+			return Collection{}, fmt.Errorf("%s: %w", m.ID, err)
+		}
+		switch {
+		case m.MType == "gauge" && m.Value != nil:
+			if !pkg.FloatIsNumber(*m.Value) {
+				return Collection{}, fmt.Errorf("%s: gauge value must be a number", m.ID)
+			}
+			result.Gauges = append(result.Gauges, GaugeFromHandler(m))
+		case m.MType == "counter" && m.Delta != nil:
+			c := CounterFromHandler(m)
+			if err := c.IsValid(); err != nil {
+				return Collection{}, fmt.Errorf("%s: %w", m.ID, err)
+			}
+			result.Counters = append(result.Counters, c)
+		default:
+			return Collection{}, fmt.Errorf("%s: unsupported metric of type %q", m.ID, m.MType)
+		}
+	}
+	return result, nil
+}
+
+func parsePluginLines(out []byte) (Collection, error) {
+	var result Collection
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 || len(fields) > 4 {
+			return Collection{}, fmt.Errorf("line %d: expected \"name type value [labels]\"", line)
+		}
+
+		if err := CheckName(fields[0]); err != nil {
+			return Collection{}, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		var lbls labels.Labels
+		if len(fields) == 4 {
+			var err error
+			if lbls, err = labels.Parse(fields[3]); err != nil {
+				return Collection{}, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+
+		switch fields[1] {
+		case "gauge":
+			value, err := strconv.ParseFloat(fields[2], 64)
+			if err != nil {
+				return Collection{}, fmt.Errorf("line %d: %w", line, err)
+			}
+			if !pkg.FloatIsNumber(value) {
+				return Collection{}, fmt.Errorf("line %d: gauge value must be a number", line)
+			}
+			result.Gauges = append(result.Gauges, Gauge{Name: fields[0], Value: value, Labels: lbls})
+		case "counter":
+			value, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				return Collection{}, fmt.Errorf("line %d: %w", line, err)
+			}
+			c := Counter{Name: fields[0], Value: value, Labels: lbls}
+			if err := c.IsValid(); err != nil {
+				return Collection{}, fmt.Errorf("line %d: %w", line, err)
+			}
+			result.Counters = append(result.Counters, c)
+		default:
+			return Collection{}, fmt.Errorf("line %d: unsupported metric type %q", line, fields[1])
+		}
+	}
+	return result, scanner.Err()
+}
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/OmAsana/yapraktikum/internal/labels"
+)
+
+func TestParseExecCommands(t *testing.T) {
+	assert.Equal(t, []ExecCommand{
+		{Name: "queue_depth.sh", Path: "/opt/plugins/queue_depth.sh", Args: []string{}},
+		{Name: "cert_expiry", Path: "cert_expiry", Args: []string{"-host", "example.com"}},
+	}, ParseExecCommands("/opt/plugins/queue_depth.sh; cert_expiry -host example.com;"))
+}
+
+func Test_parsePluginOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    Collection
+		wantErr bool
+	}{
+		{
+			name: "lines",
+			out:  "QueueDepth gauge 12.5\n\nJobsDone counter 3 queue=emails\n",
+			want: Collection{
+				Gauges:   []Gauge{{Name: "QueueDepth", Value: 12.5}},
+				Counters: []Counter{{Name: "JobsDone", Value: 3, Labels: labels.Labels{"queue": "emails"}}},
+			},
+		},
+		{
+			name: "json",
+			out:  `[{"id": "CertExpiry", "type": "gauge", "value": 30, "labels": {"host": "example.com"}}, {"id": "Renewals", "type": "counter", "delta": 1}]`,
+			want: Collection{
+				Gauges:   []Gauge{{Name: "CertExpiry", Value: 30, Labels: labels.Labels{"host": "example.com"}}},
+				Counters: []Counter{{Name: "Renewals", Value: 1}},
+			},
+		},
+		{name: "unknown type", out: "QueueDepth summary 1", wantErr: true},
+		{name: "missing value", out: "QueueDepth gauge", wantErr: true},
+		{name: "negative counter", out: "JobsDone counter -1", wantErr: true},
+		{name: "nan gauge", out: "QueueDepth gauge NaN", wantErr: true},
+		{name: "broken json", out: `[{"id": "CertExpiry"`, wantErr: true},
+		{name: "reserved name", out: "agent_collections counter 1", wantErr: true},
+		{name: "reserved name in json", out: `[{"id": "agent_batch_size", "type": "gauge", "value": 1}]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePluginOutput([]byte(tt.out))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestExecCollector(t *testing.T) {
+	c := NewExecCollector([]ExecCommand{
+		{Name: "ok", Path: "sh", Args: []string{"-c", "echo QueueDepth gauge 7"}},
+		{Name: "failing", Path: "sh", Args: []string{"-c", "exit 1"}},
+		{Name: "garbage", Path: "sh", Args: []string{"-c", "echo hello"}},
+		{Name: "slow", Path: "sleep", Args: []string{"5"}},
+	}, 100*time.Millisecond)
+
+	start := time.Now()
+	collection, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	assert.Equal(t, []Gauge{{Name: "QueueDepth", Value: 7}}, collection.Gauges)
+
+	self := map[string]int64{}
+	for _, counter := range collection.Counters {
+		self[counter.Name+"/"+counter.Labels["plugin"]] = counter.Value
+	}
+	assert.Equal(t, map[string]int64{
+		"agent_plugin_errors/ok":        0,
+		"agent_plugin_timeouts/ok":      0,
+		"agent_plugin_errors/failing":   1,
+		"agent_plugin_timeouts/failing": 0,
+		"agent_plugin_errors/garbage":   1,
+		"agent_plugin_timeouts/garbage": 0,
+		"agent_plugin_errors/slow":      0,
+		"agent_plugin_timeouts/slow":    1,
+	}, self)
+}
+
+func TestExecCollector_KillsChildren(t *testing.T) {
+	c := NewExecCollector([]ExecCommand{
+		{Name: "script", Path: "sh", Args: []string{"-c", "sleep 3; echo QueueDepth gauge 7"}},
+	}, 200*time.Millisecond)
+
+	start := time.Now()
+	collection, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.Empty(t, collection.Gauges)
+	assert.Contains(t, collection.Counters, Counter{Name: "agent_plugin_timeouts", Value: 1, Labels: labels.Labels{"plugin": "script"}})
+}
+
+func TestRegistry_ExecCollector(t *testing.T) {
+	r := NewRegistry()
+	r.collectors = []Collector{NewExecCollector([]ExecCommand{
+		{Name: "jobs", Path: "sh", Args: []string{"-c", "echo JobsDone counter 2; echo QueueDepth gauge 5"}},
+	}, time.Second)}
+
+	require.NoError(t, r.Collect(context.Background()))
+	require.NoError(t, r.Collect(context.Background()))
+
+	snapshot := r.Export()
+	assert.Contains(t, snapshot.Gauges, Gauge{Name: "QueueDepth", Value: 5})
+	assert.Contains(t, snapshot.Counters, Counter{Name: "JobsDone", Value: 4})
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package metrics
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts cmd in a process group of its own.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	// A negative pid signals every process in the group.
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
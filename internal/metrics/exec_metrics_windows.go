@@ -0,0 +1,11 @@
+package metrics
+
+import "os/exec"
+
+// setProcessGroup does nothing on Windows, only the plugin itself is killed
+// on timeout.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"context"
+	"sort"
+
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/net"
+
+	"github.com/OmAsana/yapraktikum/internal/labels"
+)
+
+// diskUsage reports every mounted partition. Mounts that cannot be read, such
+// as unreachable network shares, are skipped.
+func diskUsage(ctx context.Context) ([]Gauge, error) {
+	partitions, err := disk.PartitionsWithContext(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Gauge
+	for _, p := range partitions {
+		usage, err := disk.UsageWithContext(ctx, p.Mountpoint)
+		if err != nil {
+			continue
+		}
+
+		lbls := labels.Labels{"mountpoint": p.Mountpoint}
+		result = append(result,
+			Gauge{Name: "DiskTotal", Value: float64(usage.Total), Labels: lbls},
+			Gauge{Name: "DiskUsed", Value: float64(usage.Used), Labels: lbls},
+			Gauge{Name: "DiskFree", Value: float64(usage.Free), Labels: lbls},
+			Gauge{Name: "DiskUtilization", Value: usage.UsedPercent, Labels: lbls},
+		)
+	}
+	return result, nil
+}
+
+func diskIO(ctx context.Context) (Collection, error) {
+	stats, err := disk.IOCountersWithContext(ctx)
+	if err != nil {
+		return Collection{}, err
+	}
+
+	devices := make([]string, 0, len(stats))
+	for device := range stats {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+
+	var result Collection
+	for _, device := range devices {
+		s := stats[device]
+		lbls := labels.Labels{"device": device}
+		result.Totals = append(result.Totals,
+			Counter{Name: "DiskReadBytes", Value: int64(s.ReadBytes), Labels: lbls},
+			Counter{Name: "DiskWriteBytes", Value: int64(s.WriteBytes), Labels: lbls},
+			Counter{Name: "DiskReads", Value: int64(s.ReadCount), Labels: lbls},
+			Counter{Name: "DiskWrites", Value: int64(s.WriteCount), Labels: lbls},
+		)
+	}
+	return result, nil
+}
+
+func netIO(ctx context.Context) (Collection, error) {
+	stats, err := net.IOCountersWithContext(ctx, true)
+	if err != nil {
+		return Collection{}, err
+	}
+
+	var result Collection
+	for _, s := range stats {
+		lbls := labels.Labels{"interface": s.Name}
+		result.Totals = append(result.Totals,
+			Counter{Name: "NetBytesSent", Value: int64(s.BytesSent), Labels: lbls},
+			Counter{Name: "NetBytesRecv", Value: int64(s.BytesRecv), Labels: lbls},
+			Counter{Name: "NetPacketsSent", Value: int64(s.PacketsSent), Labels: lbls},
+			Counter{Name: "NetPacketsRecv", Value: int64(s.PacketsRecv), Labels: lbls},
+		)
+	}
+	return result, nil
+}
+
+func loadAverage(ctx context.Context) ([]Gauge, error) {
+	avg, err := load.AvgWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []Gauge{
+		{Name: "Load1", Value: avg.Load1},
+		{Name: "Load5", Value: avg.Load5},
+		{Name: "Load15", Value: avg.Load15},
+	}, nil
+}
+
+func hostUptime(ctx context.Context) ([]Gauge, error) {
+	uptime, err := host.UptimeWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []Gauge{{Name: "Uptime", Value: float64(uptime)}}, nil
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostCollectors(t *testing.T) {
+	ctx := context.Background()
+
+	gauges, err := loadAverage(ctx)
+	require.NoError(t, err)
+	assert.Len(t, gauges, 3)
+
+	gauges, err = hostUptime(ctx)
+	require.NoError(t, err)
+	assert.Positive(t, gauges[0].Value)
+
+	collection, err := netIO(ctx)
+	require.NoError(t, err)
+	for _, c := range collection.Totals {
+		assert.NotEmpty(t, c.Labels["interface"])
+	}
+}
+
+func TestRegistry_Totals(t *testing.T) {
+	var readings = [][]Counter{
+		{{Name: "NetBytesSent", Value: 100}},
+		{{Name: "NetBytesSent", Value: 150}},
+		{{Name: "NetBytesSent", Value: 180}},
+		{{Name: "NetBytesSent", Value: 20}},
+	}
+	poll := 0
+	r := NewRegistry()
+	r.collectors = []Collector{NewCollector("net", func(ctx context.Context) (Collection, error) {
+		defer func() { poll++ }()
+		return Collection{Totals: readings[poll]}, nil
+	})}
+
+	var want = []int64{0, 50, 30, 20}
+	for i := range readings {
+		require.NoError(t, r.Collect(context.Background()))
+		snapshot := r.Export()
+		require.Len(t, snapshot.Counters, 2)
+		assert.Equal(t, want[i], snapshot.Counters[0].Value, "poll %d", i)
+		r.Commit(snapshot)
+	}
+}
@@ -0,0 +1,212 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+
+	"github.com/OmAsana/yapraktikum/internal/labels"
+)
+
+// ProcessCollector reports resource usage of processes matched by name
+// patterns or listed in PID files. Series are labeled by process name only,
+// so a restarted service keeps its series: usage of processes sharing a name
+// is summed, while uptime and pid are those of the oldest one.
+type ProcessCollector struct {
+	patterns []*regexp.Regexp
+	pidFiles []string
+
+	mu sync.Mutex
+	// seen keeps processes between polls, CPU percent is measured since
+	// the previous poll.
+	seen map[int32]*trackedProcess
+}
+
+type trackedProcess struct {
+	proc       *process.Process
+	createTime int64
+}
+
+// NewProcessCollector compiles name patterns. At least one pattern or PID
+// file is required.
+func NewProcessCollector(patterns []string, pidFiles []string) (*ProcessCollector, error) {
+	if len(patterns) == 0 && len(pidFiles) == 0 {
+		return nil, fmt.Errorf("process collector needs name patterns or PID files")
+	}
+
+	c := &ProcessCollector{pidFiles: pidFiles, seen: map[int32]*trackedProcess{}}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid process pattern %q: %w", p, err)
+		}
+		c.patterns = append(c.patterns, re)
+	}
+	return c, nil
+}
+
+func (c *ProcessCollector) Name() string {
+	return "process"
+}
+
+func (c *ProcessCollector) Collect(ctx context.Context) (Collection, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pids, err := c.matchPIDs(ctx)
+	if err != nil {
+		return Collection{}, err
+	}
+
+	seen := make(map[int32]*trackedProcess, len(pids))
+	groups := map[string]*processGroup{}
+	var names []string
+	for _, pid := range pids {
+		tracked, err := c.track(ctx, pid)
+		if err != nil {
+			// The process exited since it was matched.
+			continue
+		}
+		seen[pid] = tracked
+
+		name, err := tracked.proc.NameWithContext(ctx)
+		if err != nil {
+			continue
+		}
+		g, ok := groups[name]
+		if !ok {
+			g = &processGroup{name: name, usage: map[string]float64{}}
+			groups[name] = g
+			names = append(names, name)
+		}
+		g.add(ctx, tracked)
+	}
+	c.seen = seen
+
+	var result Collection
+	for _, name := range names {
+		result.Gauges = append(result.Gauges, groups[name].gauges()...)
+	}
+	return result, nil
+}
+
+// matchPIDs returns pids from PID files followed by processes whose name
+// matches any pattern, without duplicates.
+func (c *ProcessCollector) matchPIDs(ctx context.Context) ([]int32, error) {
+	var pids []int32
+	found := map[int32]bool{}
+	add := func(pid int32) {
+		if !found[pid] {
+			found[pid] = true
+			pids = append(pids, pid)
+		}
+	}
+
+	for _, path := range c.pidFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			// The service is not running.
+			continue
+		}
+		pid, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PID file %s: %w", path, err)
+		}
+		add(int32(pid))
+	}
+
+	if len(c.patterns) == 0 {
+		return pids, nil
+	}
+
+	procs, err := process.ProcessesWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range procs {
+		name, err := p.NameWithContext(ctx)
+		if err != nil {
+			continue
+		}
+		for _, re := range c.patterns {
+			if re.MatchString(name) {
+				add(p.Pid)
+				break
+			}
+		}
+	}
+	return pids, nil
+}
+
+// track returns the process seen on the previous poll, unless pid was reused
+// by another process since then.
+func (c *ProcessCollector) track(ctx context.Context, pid int32) (*trackedProcess, error) {
+	proc, err := process.NewProcessWithContext(ctx, pid)
+	if err != nil {
+		return nil, err
+	}
+	createTime, err := proc.CreateTimeWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if prev, ok := c.seen[pid]; ok && prev.createTime == createTime {
+		return prev, nil
+	}
+	return &trackedProcess{proc: proc, createTime: createTime}, nil
+}
+
+// processUsageGauges are summed over processes sharing a name.
+var processUsageGauges = []string{"ProcessCPUPercent", "ProcessRSS", "ProcessOpenFDs", "ProcessThreads"}
+
+// processGroup is usage of processes sharing a name.
+type processGroup struct {
+	name   string
+	count  int
+	oldest *trackedProcess
+	// usage holds gauges readable for at least one of the processes, the
+	// agent may have no permission to read the rest.
+	usage map[string]float64
+}
+
+func (g *processGroup) add(ctx context.Context, t *trackedProcess) {
+	g.count++
+	if g.oldest == nil || t.createTime < g.oldest.createTime {
+		g.oldest = t
+	}
+
+	if percent, err := t.proc.PercentWithContext(ctx, 0); err == nil {
+		g.usage["ProcessCPUPercent"] += percent
+	}
+	if mem, err := t.proc.MemoryInfoWithContext(ctx); err == nil {
+		g.usage["ProcessRSS"] += float64(mem.RSS)
+	}
+	if fds, err := t.proc.NumFDsWithContext(ctx); err == nil {
+		g.usage["ProcessOpenFDs"] += float64(fds)
+	}
+	if threads, err := t.proc.NumThreadsWithContext(ctx); err == nil {
+		g.usage["ProcessThreads"] += float64(threads)
+	}
+}
+
+func (g *processGroup) gauges() []Gauge {
+	lbls := labels.Labels{"process": g.name}
+	result := []Gauge{
+		{Name: "ProcessCount", Value: float64(g.count), Labels: lbls},
+		{Name: "ProcessPID", Value: float64(g.oldest.proc.Pid), Labels: lbls},
+		{Name: "ProcessUptime", Value: time.Since(time.UnixMilli(g.oldest.createTime)).Seconds(), Labels: lbls},
+	}
+	for _, name := range processUsageGauges {
+		if value, ok := g.usage[name]; ok {
+			result = append(result, Gauge{Name: name, Value: value, Labels: lbls})
+		}
+	}
+	return result
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/OmAsana/yapraktikum/internal/labels"
+)
+
+func TestProcessCollector(t *testing.T) {
+	cmd := exec.Command("sleep", "30")
+	require.NoError(t, cmd.Start())
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+	pidFile := filepath.Join(t.TempDir(), "sleep.pid")
+	require.NoError(t, os.WriteFile(pidFile, []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0600))
+
+	tests := []struct {
+		name     string
+		patterns []string
+		pidFiles []string
+	}{
+		{name: "pattern", patterns: []string{"^sleep$"}},
+		{name: "pid file", pidFiles: []string{pidFile, filepath.Join(t.TempDir(), "missing.pid")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewProcessCollector(tt.patterns, tt.pidFiles)
+			require.NoError(t, err)
+
+			// CPU percent is measured from the second poll on.
+			_, err = c.Collect(context.Background())
+			require.NoError(t, err)
+			collection, err := c.Collect(context.Background())
+			require.NoError(t, err)
+
+			gauges := map[string]float64{}
+			for _, g := range collection.Gauges {
+				if g.Labels["process"] == "sleep" {
+					assert.Equal(t, labels.Labels{"process": "sleep"}, g.Labels)
+					gauges[g.Name] = g.Value
+				}
+			}
+			for _, name := range []string{"ProcessCount", "ProcessPID", "ProcessUptime", "ProcessCPUPercent", "ProcessRSS", "ProcessOpenFDs", "ProcessThreads"} {
+				assert.Contains(t, gauges, name)
+			}
+			if tt.pidFiles != nil {
+				assert.Equal(t, 1.0, gauges["ProcessCount"])
+				assert.Equal(t, float64(cmd.Process.Pid), gauges["ProcessPID"])
+			}
+		})
+	}
+
+	_, err := NewProcessCollector(nil, nil)
+	assert.Error(t, err)
+	_, err = NewProcessCollector([]string{"("}, nil)
+	assert.Error(t, err)
+}
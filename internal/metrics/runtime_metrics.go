@@ -5,11 +5,9 @@ import (
 	"fmt"
 	"reflect"
 	"runtime"
-	"sync"
 
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/mem"
-	"golang.org/x/sync/errgroup"
 )
 
 var memoryStats = []string{
@@ -44,39 +42,17 @@ var memoryStats = []string{
 	"Sys",
 }
 
-type systemStatCollector func() ([]Gauge, error)
-
+// CollectRuntimeMetrics runs DefaultCollectors once.
 func CollectRuntimeMetrics(ctx context.Context) ([]Gauge, error) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
-
-	g, _ := errgroup.WithContext(ctx)
-
-	collectors := []systemStatCollector{runtimeMemStats, totalAndFreeMem, cpuUtilization}
-	var result []Gauge
-	writeMu := sync.Mutex{}
-
-	for _, collector := range collectors {
-		collector := collector
-		g.Go(func() error {
-			stats, err := collector()
-			if err == nil {
-				writeMu.Lock()
-				result = append(result, stats...)
-				writeMu.Unlock()
-			}
-			return err
-		})
-	}
-	if err := g.Wait(); err != nil {
-		return nil, err
-	}
-	return result, nil
+	collection, err := runCollectors(ctx, DefaultCollectors(), DefaultCollectorTimeout)
+	return collection.Gauges, err
 }
 
-func totalAndFreeMem() ([]Gauge, error) {
-	memStats, err := mem.VirtualMemory()
+func totalAndFreeMem(ctx context.Context) ([]Gauge, error) {
+	memStats, err := mem.VirtualMemoryWithContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -90,8 +66,8 @@ func totalAndFreeMem() ([]Gauge, error) {
 		nil
 }
 
-func cpuUtilization() ([]Gauge, error) {
-	util, err := cpu.Percent(0, true)
+func cpuUtilization(ctx context.Context) ([]Gauge, error) {
+	util, err := cpu.PercentWithContext(ctx, 0, true)
 	if err != nil {
 		return nil, err
 	}
@@ -103,7 +79,7 @@ func cpuUtilization() ([]Gauge, error) {
 	return result, nil
 }
 
-func runtimeMemStats() ([]Gauge, error) {
+func runtimeMemStats(ctx context.Context) ([]Gauge, error) {
 	mStats := new(runtime.MemStats)
 	runtime.ReadMemStats(mStats)
 
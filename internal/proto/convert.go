@@ -0,0 +1,40 @@
+package proto
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative metrics.proto
+
+import (
+	"github.com/OmAsana/yapraktikum/internal/handlers"
+	"github.com/OmAsana/yapraktikum/internal/labels"
+)
+
+func FromHandler(m handlers.Metrics) *Metric {
+	return &Metric{
+		Id:     m.ID,
+		Type:   m.MType,
+		Delta:  m.Delta,
+		Value:  m.Value,
+		Hash:   m.Hash,
+		Labels: m.Labels,
+
+		Buckets: m.Buckets,
+		Counts:  m.Counts,
+		Sum:     m.Sum,
+		Count:   m.Count,
+	}
+}
+
+func (x *Metric) ToHandler() handlers.Metrics {
+	return handlers.Metrics{
+		ID:     x.GetId(),
+		MType:  x.GetType(),
+		Delta:  x.Delta,
+		Value:  x.Value,
+		Hash:   x.GetHash(),
+		Labels: labels.FromMap(x.GetLabels()),
+
+		Buckets: x.GetBuckets(),
+		Counts:  x.GetCounts(),
+		Sum:     x.Sum,
+		Count:   x.Count,
+	}
+}
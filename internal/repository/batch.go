@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+	"github.com/OmAsana/yapraktikum/internal/pkg"
+)
+
+// DefaultIdempotencyWindow is how long repositories remember idempotency
+// keys by default. It covers agents replaying batches queued for an hour.
+var DefaultIdempotencyWindow = time.Hour
+
+// IdempotentResponse is the answer to a request with an idempotency key.
+type IdempotentResponse struct {
+	StatusCode int
+	Body       []byte
+}
+
+// ValidateBatch checks every metric of a batch, so ApplyBatch refuses an
+// invalid batch before writing anything. Bucket mismatches with stored
+// histograms are left to the repository.
+func ValidateBatch(gauges []metrics.Gauge, counters []metrics.Counter, histograms []metrics.Histogram) error {
+	for _, g := range gauges {
+		if !pkg.FloatIsNumber(g.Value) {
+			return fmt.Errorf("%w: %s", ErrorGaugeIsNotValid, g.Name)
+		}
+	}
+	for _, c := range counters {
+		if err := c.IsValid(); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrorCounterIsNoValid, c.Name, err)
+		}
+	}
+	for _, h := range histograms {
+		if err := h.IsValid(); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrorHistogramIsNotValid, h.Name, err)
+		}
+	}
+	return nil
+}
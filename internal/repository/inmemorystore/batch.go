@@ -0,0 +1,85 @@
+package inmemorystore
+
+import (
+	"context"
+	"time"
+
+	"github.com/OmAsana/yapraktikum/internal/labels"
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+	"github.com/OmAsana/yapraktikum/internal/repository"
+)
+
+// ApplyBatch applies the whole batch under a single lock. Histograms are
+// merged into copies first and the store is only touched once all of them
+// fit, so a bucket mismatch leaves nothing of the batch behind.
+func (r *InMemoryStore) ApplyBatch(ctx context.Context, gauges []metrics.Gauge, counters []metrics.Counter, histograms []metrics.Histogram) error {
+	if err := repository.ValidateBatch(gauges, counters, histograms); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	return r.applyBatch(gauges, counters, histograms)
+}
+
+// ApplyBatchOnce checks and saves key under the same lock as the batch.
+func (r *InMemoryStore) ApplyBatchOnce(ctx context.Context, key string, requestHash string, response repository.IdempotentResponse,
+	gauges []metrics.Gauge, counters []metrics.Counter, histograms []metrics.Histogram) (repository.IdempotentResponse, bool, error) {
+	if err := repository.ValidateBatch(gauges, counters, histograms); err != nil {
+		return response, false, err
+	}
+	if err := ctx.Err(); err != nil {
+		return response, false, err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	if saved, ok := r.idempotency.lookup(key, now); ok {
+		if saved.requestHash != requestHash {
+			return response, false, repository.ErrorIdempotencyKeyReused
+		}
+		return saved.response, true, nil
+	}
+	if err := r.applyBatch(gauges, counters, histograms); err != nil {
+		return response, false, err
+	}
+	r.idempotency.save(key, requestHash, response, now)
+	return response, false, nil
+}
+
+// applyBatch must be called with the store lock held.
+func (r *InMemoryStore) applyBatch(gauges []metrics.Gauge, counters []metrics.Counter, histograms []metrics.Histogram) error {
+	merged := make(map[string]metrics.Histogram, len(histograms))
+	for _, h := range histograms {
+		h.Labels = labels.FromMap(h.Labels)
+		key := h.SeriesKey()
+		stored, ok := merged[key]
+		if !ok {
+			stored, ok = r.histograms[key]
+		}
+		if !ok {
+			stored = metrics.NewHistogram(h.Name, h.Buckets)
+			stored.Labels = h.Labels
+		}
+		if err := stored.Merge(h); err != nil {
+			return repository.ErrorHistogramBucketsMismatch
+		}
+		merged[key] = stored
+	}
+
+	for _, g := range gauges {
+		r.storeGauge(g)
+	}
+	for _, c := range counters {
+		r.storeCounter(c)
+	}
+	for key, h := range merged {
+		r.histograms[key] = h
+	}
+	return nil
+}
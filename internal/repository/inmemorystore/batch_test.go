@@ -0,0 +1,194 @@
+package inmemorystore
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+	"github.com/OmAsana/yapraktikum/internal/repository"
+)
+
+func TestInMemoryStore_ApplyBatch(t *testing.T) {
+	ctx := context.Background()
+	latency := func(counts ...uint64) metrics.Histogram {
+		h := metrics.NewHistogram("latency", []float64{1})
+		h.Counts = counts
+		for _, c := range counts {
+			h.Count += c
+		}
+		return h
+	}
+
+	setup := func(t *testing.T) *InMemoryStore {
+		repo := NewDefaultInMemoryRepo()
+		require.NoError(t, repo.ApplyBatch(ctx,
+			[]metrics.Gauge{{Name: "Alloc", Value: 1}},
+			[]metrics.Counter{{Name: "PollCount", Value: 1}},
+			[]metrics.Histogram{latency(1, 0)},
+		))
+		return repo
+	}
+
+	assertUnchanged := func(t *testing.T, repo *InMemoryStore) {
+		g, err := repo.RetrieveGauge(ctx, "Alloc", nil)
+		require.NoError(t, err)
+		assert.Equal(t, float64(1), g.Value)
+		c, err := repo.RetrieveCounter(ctx, "PollCount", nil)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), c.Value)
+		h, err := repo.RetrieveHistogram(ctx, "latency", nil)
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{1, 0}, h.Counts)
+	}
+
+	t.Run("applies everything", func(t *testing.T) {
+		repo := setup(t)
+		require.NoError(t, repo.ApplyBatch(ctx,
+			[]metrics.Gauge{{Name: "Alloc", Value: 2}},
+			[]metrics.Counter{{Name: "PollCount", Value: 2}, {Name: "PollCount", Value: 3}},
+			[]metrics.Histogram{latency(0, 1), latency(1, 0)},
+		))
+
+		g, err := repo.RetrieveGauge(ctx, "Alloc", nil)
+		require.NoError(t, err)
+		assert.Equal(t, float64(2), g.Value)
+		c, err := repo.RetrieveCounter(ctx, "PollCount", nil)
+		require.NoError(t, err)
+		assert.Equal(t, int64(6), c.Value)
+		h, err := repo.RetrieveHistogram(ctx, "latency", nil)
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{2, 1}, h.Counts)
+		assert.Equal(t, uint64(3), h.Count)
+	})
+
+	t.Run("buckets mismatch", func(t *testing.T) {
+		repo := setup(t)
+		other := metrics.NewHistogram("latency", []float64{1, 2})
+		err := repo.ApplyBatch(ctx,
+			[]metrics.Gauge{{Name: "Alloc", Value: 2}},
+			[]metrics.Counter{{Name: "PollCount", Value: 2}},
+			[]metrics.Histogram{latency(0, 1), other},
+		)
+		assert.ErrorIs(t, err, repository.ErrorHistogramBucketsMismatch)
+		assertUnchanged(t, repo)
+	})
+
+	t.Run("invalid counter", func(t *testing.T) {
+		repo := setup(t)
+		err := repo.ApplyBatch(ctx,
+			[]metrics.Gauge{{Name: "Alloc", Value: 2}},
+			[]metrics.Counter{{Name: "PollCount", Value: 2}, {Name: "PollCount", Value: -1}},
+			nil,
+		)
+		assert.ErrorIs(t, err, repository.ErrorCounterIsNoValid)
+		assertUnchanged(t, repo)
+	})
+
+	t.Run("invalid gauge", func(t *testing.T) {
+		repo := setup(t)
+		err := repo.ApplyBatch(ctx,
+			[]metrics.Gauge{{Name: "Alloc", Value: math.NaN()}},
+			[]metrics.Counter{{Name: "PollCount", Value: 2}},
+			nil,
+		)
+		assert.ErrorIs(t, err, repository.ErrorGaugeIsNotValid)
+		assertUnchanged(t, repo)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		repo := setup(t)
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		err := repo.ApplyBatch(canceled, nil, []metrics.Counter{{Name: "PollCount", Value: 2}}, nil)
+		assert.ErrorIs(t, err, context.Canceled)
+		assertUnchanged(t, repo)
+	})
+}
+
+func TestInMemoryStore_ApplyBatchOnce(t *testing.T) {
+	ctx := context.Background()
+	counters := []metrics.Counter{{Name: "PollCount", Value: 2}}
+	response := repository.IdempotentResponse{StatusCode: 200, Body: []byte("first")}
+
+	assertCounter := func(t *testing.T, repo *InMemoryStore, want int64) {
+		c, err := repo.RetrieveCounter(ctx, "PollCount", nil)
+		require.NoError(t, err)
+		assert.Equal(t, want, c.Value)
+	}
+
+	t.Run("replays saved response", func(t *testing.T) {
+		repo := NewDefaultInMemoryRepo()
+		saved, replayed, err := repo.ApplyBatchOnce(ctx, "key", "hash", response, nil, counters, nil)
+		require.NoError(t, err)
+		assert.False(t, replayed)
+		assert.Equal(t, response, saved)
+
+		saved, replayed, err = repo.ApplyBatchOnce(ctx, "key", "hash", repository.IdempotentResponse{StatusCode: 200}, nil, counters, nil)
+		require.NoError(t, err)
+		assert.True(t, replayed)
+		assert.Equal(t, response, saved)
+		assertCounter(t, repo, 2)
+
+		_, replayed, err = repo.ApplyBatchOnce(ctx, "other", "hash", response, nil, counters, nil)
+		require.NoError(t, err)
+		assert.False(t, replayed)
+		assertCounter(t, repo, 4)
+	})
+
+	t.Run("key reused with another batch", func(t *testing.T) {
+		repo := NewDefaultInMemoryRepo()
+		_, _, err := repo.ApplyBatchOnce(ctx, "key", "hash", response, nil, counters, nil)
+		require.NoError(t, err)
+
+		_, replayed, err := repo.ApplyBatchOnce(ctx, "key", "other hash", response, nil, counters, nil)
+		assert.ErrorIs(t, err, repository.ErrorIdempotencyKeyReused)
+		assert.False(t, replayed)
+		assertCounter(t, repo, 2)
+	})
+
+	t.Run("failed batch is not saved", func(t *testing.T) {
+		repo := NewDefaultInMemoryRepo()
+		_, _, err := repo.ApplyBatchOnce(ctx, "key", "hash", response, nil, []metrics.Counter{{Name: "PollCount", Value: -1}}, nil)
+		assert.ErrorIs(t, err, repository.ErrorCounterIsNoValid)
+
+		_, replayed, err := repo.ApplyBatchOnce(ctx, "key", "hash", response, nil, counters, nil)
+		require.NoError(t, err)
+		assert.False(t, replayed)
+		assertCounter(t, repo, 2)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		repo, err := NewInMemoryRepo(WithIdempotencyWindow(0))
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			_, replayed, err := repo.ApplyBatchOnce(ctx, "key", "hash", response, nil, counters, nil)
+			require.NoError(t, err)
+			assert.False(t, replayed)
+		}
+		assertCounter(t, repo, 4)
+	})
+}
+
+func Test_idempotencyKeys(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	keys := newIdempotencyKeys(time.Minute)
+	keys.save("a", "hash a", repository.IdempotentResponse{StatusCode: 200}, now)
+	keys.save("b", "hash b", repository.IdempotentResponse{StatusCode: 201}, now.Add(30*time.Second))
+
+	got, ok := keys.lookup("a", now.Add(59*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 200, got.response.StatusCode)
+	assert.Equal(t, "hash a", got.requestHash)
+
+	_, ok = keys.lookup("a", now.Add(time.Minute))
+	assert.False(t, ok)
+	got, ok = keys.lookup("b", now.Add(time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, 201, got.response.StatusCode)
+	assert.Equal(t, []string{"b"}, keys.order)
+}
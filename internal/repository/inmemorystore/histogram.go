@@ -0,0 +1,69 @@
+package inmemorystore
+
+import (
+	"context"
+
+	"github.com/OmAsana/yapraktikum/internal/labels"
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+	"github.com/OmAsana/yapraktikum/internal/repository"
+)
+
+func (r *InMemoryStore) StoreHistogram(ctx context.Context, histogram metrics.Histogram) repository.RepositoryError {
+	r.Lock()
+	defer r.Unlock()
+	return r.storeHistogram(histogram)
+}
+
+// storeHistogram must be called with the store lock held.
+func (r *InMemoryStore) storeHistogram(histogram metrics.Histogram) repository.RepositoryError {
+	if err := histogram.IsValid(); err != nil {
+		return repository.ErrorHistogramIsNotValid
+	}
+
+	histogram.Labels = labels.FromMap(histogram.Labels)
+	key := histogram.SeriesKey()
+	stored, ok := r.histograms[key]
+	if !ok {
+		stored = metrics.NewHistogram(histogram.Name, histogram.Buckets)
+		stored.Labels = histogram.Labels
+	}
+	if err := stored.Merge(histogram); err != nil {
+		return repository.ErrorHistogramBucketsMismatch
+	}
+	r.histograms[key] = stored
+	return nil
+}
+
+func (r *InMemoryStore) RetrieveHistogram(ctx context.Context, name string, lbls labels.Labels) (metrics.Histogram, repository.RepositoryError) {
+	r.RLock()
+	defer r.RUnlock()
+	if v, ok := r.histograms[labels.SeriesKey(name, lbls)]; ok {
+		return v, nil
+	}
+	return metrics.Histogram{}, repository.ErrorHistogramNotFound
+}
+
+func (r *InMemoryStore) ListStoredHistograms(ctx context.Context, matchers ...labels.Matcher) ([]metrics.Histogram, repository.RepositoryError) {
+	var histograms []metrics.Histogram
+
+	r.RLock()
+	defer r.RUnlock()
+	for _, v := range r.histograms {
+		if labels.MatchAll(v.Labels, matchers) {
+			histograms = append(histograms, v)
+		}
+	}
+	return histograms, nil
+}
+
+func (r *InMemoryStore) WriteBulkHistograms(ctx context.Context, histograms []metrics.Histogram) error {
+	for _, h := range histograms {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := r.StoreHistogram(ctx, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
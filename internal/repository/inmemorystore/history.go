@@ -0,0 +1,59 @@
+package inmemorystore
+
+import (
+	"time"
+
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+)
+
+// ringBuffer keeps the last len(samples) samples of a single series.
+type ringBuffer struct {
+	samples []metrics.Sample
+	head    int
+	size    int
+}
+
+func newRingBuffer(capacity int) *ringBuffer {
+	return &ringBuffer{samples: make([]metrics.Sample, capacity)}
+}
+
+func (b *ringBuffer) push(s metrics.Sample) {
+	b.samples[b.head] = s
+	b.head = (b.head + 1) % len(b.samples)
+	if b.size < len(b.samples) {
+		b.size++
+	}
+}
+
+// between returns samples within [from, to] ordered by time.
+func (b *ringBuffer) between(from, to time.Time) []metrics.Sample {
+	var result []metrics.Sample
+	start := (b.head - b.size + len(b.samples)) % len(b.samples)
+	for i := 0; i < b.size; i++ {
+		s := b.samples[(start+i)%len(b.samples)]
+		if s.Timestamp.Before(from) || s.Timestamp.After(to) {
+			continue
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+func historyKey(mType string, seriesKey string) string {
+	return mType + ":" + seriesKey
+}
+
+// recordSample must be called with the store lock held.
+func (r *InMemoryStore) recordSample(mType string, seriesKey string, value float64) {
+	if r.historyRetention <= 0 {
+		return
+	}
+
+	key := historyKey(mType, seriesKey)
+	b, ok := r.history[key]
+	if !ok {
+		b = newRingBuffer(r.historyCapacity)
+		r.history[key] = b
+	}
+	b.push(metrics.Sample{Timestamp: time.Now(), Value: value})
+}
@@ -0,0 +1,28 @@
+package inmemorystore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+)
+
+func TestRingBuffer(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newRingBuffer(3)
+	for i := 0; i < 5; i++ {
+		b.push(metrics.Sample{Timestamp: start.Add(time.Duration(i) * time.Second), Value: float64(i)})
+	}
+
+	got := b.between(start, start.Add(time.Minute))
+	assert.Equal(t, []metrics.Sample{
+		{Timestamp: start.Add(2 * time.Second), Value: 2},
+		{Timestamp: start.Add(3 * time.Second), Value: 3},
+		{Timestamp: start.Add(4 * time.Second), Value: 4},
+	}, got)
+
+	got = b.between(start.Add(3*time.Second), start.Add(3*time.Second))
+	assert.Equal(t, []metrics.Sample{{Timestamp: start.Add(3 * time.Second), Value: 3}}, got)
+}
@@ -0,0 +1,53 @@
+package inmemorystore
+
+import (
+	"time"
+
+	"github.com/OmAsana/yapraktikum/internal/repository"
+)
+
+type idempotencyRecord struct {
+	key         string
+	requestHash string
+	response    repository.IdempotentResponse
+	saved       time.Time
+}
+
+// idempotencyKeys remembers responses by key for window. Records are kept
+// in the order they were saved, so expired ones are dropped from the front.
+// It is guarded by the store lock.
+type idempotencyKeys struct {
+	window  time.Duration
+	records map[string]idempotencyRecord
+	order   []string
+}
+
+func newIdempotencyKeys(window time.Duration) *idempotencyKeys {
+	return &idempotencyKeys{window: window, records: map[string]idempotencyRecord{}}
+}
+
+func (k *idempotencyKeys) lookup(key string, now time.Time) (idempotencyRecord, bool) {
+	k.prune(now)
+	record, ok := k.records[key]
+	return record, ok
+}
+
+func (k *idempotencyKeys) save(key, requestHash string, response repository.IdempotentResponse, now time.Time) {
+	if k.window <= 0 {
+		return
+	}
+	k.records[key] = idempotencyRecord{key: key, requestHash: requestHash, response: response, saved: now}
+	k.order = append(k.order, key)
+}
+
+func (k *idempotencyKeys) prune(now time.Time) {
+	i := 0
+	for ; i < len(k.order); i++ {
+		record := k.records[k.order[i]]
+		if now.Sub(record.saved) < k.window {
+			break
+		}
+		delete(k.records, record.key)
+	}
+	k.order = k.order[i:]
+}
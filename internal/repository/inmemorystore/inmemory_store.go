@@ -1,11 +1,13 @@
 package inmemorystore
 
 import (
+	"context"
 	"io"
 	"sync"
 	"time"
 
 	"github.com/OmAsana/yapraktikum/internal/handlers"
+	"github.com/OmAsana/yapraktikum/internal/labels"
 	"github.com/OmAsana/yapraktikum/internal/logging"
 	"github.com/OmAsana/yapraktikum/internal/metrics"
 	"github.com/OmAsana/yapraktikum/internal/repository"
@@ -15,8 +17,10 @@ var _ repository.MetricsRepository = (*InMemoryStore)(nil)
 
 type InMemoryStore struct {
 	sync.RWMutex
-	gauges   map[string]float64
-	counters map[string]int64
+	// series are keyed by labels.SeriesKey
+	gauges     map[string]metrics.Gauge
+	counters   map[string]metrics.Counter
+	histograms map[string]metrics.Histogram
 
 	cacheWriter CacheWriter
 	cacheReader *CacheReader
@@ -25,38 +29,55 @@ type InMemoryStore struct {
 	storeFile     string
 	restore       bool
 
+	history          map[string]*ringBuffer
+	historyRetention time.Duration
+	historyCapacity  int
+
+	idempotency *idempotencyKeys
+
 	log *logging.Logger
 
 	storeSignal chan struct{}
 }
 
-func (r *InMemoryStore) WriteBulkGauges(gauges []metrics.Gauge) error {
+func (r *InMemoryStore) WriteBulkGauges(ctx context.Context, gauges []metrics.Gauge) error {
 	for _, g := range gauges {
-		if err := r.StoreGauge(g); err != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := r.StoreGauge(ctx, g); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (r *InMemoryStore) WriteBulkCounters(counters []metrics.Counter) error {
+func (r *InMemoryStore) WriteBulkCounters(ctx context.Context, counters []metrics.Counter) error {
 	for _, c := range counters {
-		if err := r.StoreCounter(c); err != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := r.StoreCounter(ctx, c); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (r *InMemoryStore) Ping() bool {
+func (r *InMemoryStore) Ping(ctx context.Context) bool {
 	return false
 }
 
 func NewDefaultInMemoryRepo() *InMemoryStore {
 	repo := &InMemoryStore{
-		gauges:   make(map[string]float64),
-		counters: make(map[string]int64),
-		log:      logging.NewNoop(),
+		gauges:     make(map[string]metrics.Gauge),
+		counters:   make(map[string]metrics.Counter),
+		histograms: make(map[string]metrics.Histogram),
+		history:    make(map[string]*ringBuffer),
+
+		idempotency: newIdempotencyKeys(repository.DefaultIdempotencyWindow),
+
+		log: logging.NewNoop(),
 	}
 
 	return repo
@@ -64,20 +85,30 @@ func NewDefaultInMemoryRepo() *InMemoryStore {
 
 func NewInMemoryRepo(opts ...Options) (*InMemoryStore, error) {
 	repo := &InMemoryStore{
-		gauges:   make(map[string]float64),
-		counters: make(map[string]int64),
+		gauges:     make(map[string]metrics.Gauge),
+		counters:   make(map[string]metrics.Counter),
+		histograms: make(map[string]metrics.Histogram),
 
 		storeInterval: 0 * time.Second,
 		storeFile:     "",
 		restore:       false,
 		storeSignal:   make(chan struct{}),
 
+		history:         make(map[string]*ringBuffer),
+		historyCapacity: DefaultHistoryCapacity,
+
+		idempotency: newIdempotencyKeys(repository.DefaultIdempotencyWindow),
+
 		log: logging.NewNoop(),
 	}
 	for _, opt := range opts {
 		opt(repo)
 	}
 
+	if repo.historyCapacity <= 0 {
+		repo.historyCapacity = DefaultHistoryCapacity
+	}
+
 	if repo.storeFile != "" {
 		if repo.restore {
 			if err := repo.restoreData(); err != nil {
@@ -122,31 +153,25 @@ func (r *InMemoryStore) flushToDiskRoutine() {
 
 }
 
-func (r *InMemoryStore) RetrieveCounter(name string) (metrics.Counter, repository.RepositoryError) {
+func (r *InMemoryStore) RetrieveCounter(ctx context.Context, name string, lbls labels.Labels) (metrics.Counter, repository.RepositoryError) {
 	r.RLock()
 	defer r.RUnlock()
-	if v, ok := r.counters[name]; ok {
-		return metrics.Counter{
-			Name:  name,
-			Value: v,
-		}, nil
+	if v, ok := r.counters[labels.SeriesKey(name, lbls)]; ok {
+		return v, nil
 	}
 	return metrics.Counter{}, repository.ErrorCounterNotFound
 }
 
-func (r *InMemoryStore) RetrieveGauge(name string) (metrics.Gauge, repository.RepositoryError) {
+func (r *InMemoryStore) RetrieveGauge(ctx context.Context, name string, lbls labels.Labels) (metrics.Gauge, repository.RepositoryError) {
 	r.RLock()
 	defer r.RUnlock()
-	if v, ok := r.gauges[name]; ok {
-		return metrics.Gauge{
-			Name:  name,
-			Value: v,
-		}, nil
+	if v, ok := r.gauges[labels.SeriesKey(name, lbls)]; ok {
+		return v, nil
 	}
 	return metrics.Gauge{}, repository.ErrorGaugeNotFound
 }
 
-func (r *InMemoryStore) StoreCounter(counter metrics.Counter) repository.RepositoryError {
+func (r *InMemoryStore) StoreCounter(ctx context.Context, counter metrics.Counter) repository.RepositoryError {
 	r.Lock()
 	defer r.Unlock()
 	err := counter.IsValid()
@@ -154,41 +179,69 @@ func (r *InMemoryStore) StoreCounter(counter metrics.Counter) repository.Reposit
 		return repository.ErrorCounterIsNoValid
 	}
 
-	_, ok := r.counters[counter.Name]
-	if ok {
-		r.counters[counter.Name] += counter.Value
-		return nil
-	}
-	r.counters[counter.Name] = counter.Value
-
+	r.storeCounter(counter)
 	return nil
 }
 
-func (r *InMemoryStore) StoreGauge(gauge metrics.Gauge) repository.RepositoryError {
+// storeCounter must be called with the store lock held.
+func (r *InMemoryStore) storeCounter(counter metrics.Counter) {
+	counter.Labels = labels.FromMap(counter.Labels)
+	key := counter.SeriesKey()
+	if stored, ok := r.counters[key]; ok {
+		counter.Value += stored.Value
+	}
+	r.counters[key] = counter
+	r.recordSample("counter", key, float64(counter.Value))
+}
+
+func (r *InMemoryStore) StoreGauge(ctx context.Context, gauge metrics.Gauge) repository.RepositoryError {
 	r.Lock()
 	defer r.Unlock()
-	r.gauges[gauge.Name] = gauge.Value
+	r.storeGauge(gauge)
 	return nil
 }
 
-func (r *InMemoryStore) ListStoredMetrics() ([]metrics.Gauge, []metrics.Counter, repository.RepositoryError) {
+// storeGauge must be called with the store lock held.
+func (r *InMemoryStore) storeGauge(gauge metrics.Gauge) {
+	gauge.Labels = labels.FromMap(gauge.Labels)
+	r.gauges[gauge.SeriesKey()] = gauge
+	r.recordSample("gauge", gauge.SeriesKey(), gauge.Value)
+}
+
+func (r *InMemoryStore) RetrieveHistory(ctx context.Context, mType string, name string, lbls labels.Labels, from, to time.Time) ([]metrics.Sample, repository.RepositoryError) {
+	if r.historyRetention <= 0 {
+		return nil, repository.ErrorHistoryDisabled
+	}
+
+	if oldest := time.Now().Add(-r.historyRetention); from.Before(oldest) {
+		from = oldest
+	}
+
+	r.RLock()
+	defer r.RUnlock()
+	b, ok := r.history[historyKey(mType, labels.SeriesKey(name, lbls))]
+	if !ok {
+		return nil, repository.ErrorHistoryNotFound
+	}
+	return b.between(from, to), nil
+}
+
+func (r *InMemoryStore) ListStoredMetrics(ctx context.Context, matchers ...labels.Matcher) ([]metrics.Gauge, []metrics.Counter, repository.RepositoryError) {
 	var gauges []metrics.Gauge
 	var counter []metrics.Counter
 
 	r.RLock()
 	defer r.RUnlock()
-	for k, v := range r.gauges {
-		gauges = append(gauges, metrics.Gauge{
-			Name:  k,
-			Value: v,
-		})
+	for _, v := range r.gauges {
+		if labels.MatchAll(v.Labels, matchers) {
+			gauges = append(gauges, v)
+		}
 	}
 
-	for k, v := range r.counters {
-		counter = append(counter, metrics.Counter{
-			Name:  k,
-			Value: v,
-		})
+	for _, v := range r.counters {
+		if labels.MatchAll(v.Labels, matchers) {
+			counter = append(counter, v)
+		}
 	}
 
 	return gauges, counter, nil
@@ -206,26 +259,21 @@ func (r *InMemoryStore) restoreData() error {
 	if err == io.EOF {
 		return nil
 	}
+	ctx := context.Background()
 	for _, m := range metricsFromDisk {
 		switch m.MType {
 		case "counter":
-
-			c := metrics.Counter{
-				Name:  m.ID,
-				Value: *m.Delta,
-			}
-
-			err := r.StoreCounter(c)
+			err := r.StoreCounter(ctx, metrics.CounterFromHandler(m))
 			if err != nil {
 				return err
 			}
 		case "gauge":
-
-			g := metrics.Gauge{
-				Name:  m.ID,
-				Value: *m.Value,
+			err := r.StoreGauge(ctx, metrics.GaugeFromHandler(m))
+			if err != nil {
+				return err
 			}
-			err := r.StoreGauge(g)
+		case "histogram":
+			err := r.StoreHistogram(ctx, metrics.HistogramFromHandler(m))
 			if err != nil {
 				return err
 			}
@@ -234,7 +282,8 @@ func (r *InMemoryStore) restoreData() error {
 	return nil
 }
 func (r *InMemoryStore) flushToDisk() {
-	gauges, counters, err := r.ListStoredMetrics()
+	ctx := context.Background()
+	gauges, counters, err := r.ListStoredMetrics(ctx)
 	if err != nil {
 		r.log.S().Error("Failed to list metrics: %w", err)
 		return
@@ -251,6 +300,15 @@ func (r *InMemoryStore) flushToDisk() {
 
 	}
 
+	histograms, err := r.ListStoredHistograms(ctx)
+	if err != nil {
+		r.log.S().Error("Failed to list histograms: %w", err)
+		return
+	}
+	for _, h := range histograms {
+		flushMetrics = append(flushMetrics, metrics.HistogramToHandlerScheme(h))
+	}
+
 	err = r.cacheWriter.WriteMultipleMetrics(&flushMetrics)
 	if err != nil {
 		r.log.S().Error("Failed to write metrics: %w", err)
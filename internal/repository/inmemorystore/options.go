@@ -6,6 +6,8 @@ import (
 	"github.com/OmAsana/yapraktikum/internal/logging"
 )
 
+const DefaultHistoryCapacity = 1024
+
 type Options func(server *InMemoryStore)
 
 func WithStoreFile(file string) Options {
@@ -31,3 +33,20 @@ func WithRestore(restore bool) Options {
 		server.restore = restore
 	}
 }
+
+// WithHistory keeps up to capacity samples per series for retention.
+// History is disabled when retention is zero.
+func WithHistory(retention time.Duration, capacity int) Options {
+	return func(server *InMemoryStore) {
+		server.historyRetention = retention
+		server.historyCapacity = capacity
+	}
+}
+
+// WithIdempotencyWindow remembers idempotency keys for window. Keys are
+// ignored when window is zero.
+func WithIdempotencyWindow(window time.Duration) Options {
+	return func(server *InMemoryStore) {
+		server.idempotency = newIdempotencyKeys(window)
+	}
+}
@@ -1,8 +1,11 @@
 package repository
 
 import (
+	"context"
 	"fmt"
+	"time"
 
+	"github.com/OmAsana/yapraktikum/internal/labels"
 	"github.com/OmAsana/yapraktikum/internal/metrics"
 )
 
@@ -12,16 +15,42 @@ var (
 	ErrorCounterNotFound  RepositoryError = fmt.Errorf("counter not found")
 	ErrorCounterIsNoValid RepositoryError = fmt.Errorf("counter is not valid")
 	ErrorGaugeNotFound    RepositoryError = fmt.Errorf("gauge not found")
+	ErrorGaugeIsNotValid  RepositoryError = fmt.Errorf("gauge is not valid")
 	ErrorInternalError    RepositoryError = fmt.Errorf("internal error")
+	ErrorHistoryDisabled  RepositoryError = fmt.Errorf("history is disabled")
+	ErrorHistoryNotFound  RepositoryError = fmt.Errorf("history not found")
+
+	ErrorHistogramNotFound        RepositoryError = fmt.Errorf("histogram not found")
+	ErrorHistogramIsNotValid      RepositoryError = fmt.Errorf("histogram is not valid")
+	ErrorHistogramBucketsMismatch RepositoryError = fmt.Errorf("histogram buckets do not match stored histogram")
+
+	ErrorIdempotencyKeyReused RepositoryError = fmt.Errorf("idempotency key was used with a different request")
 )
 
+// MetricsRepository stores metrics. Every method gives up when ctx is done,
+// so callers pass the request context to stop work nobody waits for.
 type MetricsRepository interface {
-	StoreCounter(counter metrics.Counter) RepositoryError
-	RetrieveCounter(name string) (metrics.Counter, RepositoryError)
-	StoreGauge(gauge metrics.Gauge) RepositoryError
-	RetrieveGauge(name string) (metrics.Gauge, RepositoryError)
-	ListStoredMetrics() ([]metrics.Gauge, []metrics.Counter, RepositoryError)
-	Ping() bool
-	WriteBulkGauges(gauges []metrics.Gauge) error
-	WriteBulkCounters(counters []metrics.Counter) error
+	StoreCounter(ctx context.Context, counter metrics.Counter) RepositoryError
+	RetrieveCounter(ctx context.Context, name string, lbls labels.Labels) (metrics.Counter, RepositoryError)
+	StoreGauge(ctx context.Context, gauge metrics.Gauge) RepositoryError
+	RetrieveGauge(ctx context.Context, name string, lbls labels.Labels) (metrics.Gauge, RepositoryError)
+	ListStoredMetrics(ctx context.Context, matchers ...labels.Matcher) ([]metrics.Gauge, []metrics.Counter, RepositoryError)
+	Ping(ctx context.Context) bool
+	WriteBulkGauges(ctx context.Context, gauges []metrics.Gauge) error
+	WriteBulkCounters(ctx context.Context, counters []metrics.Counter) error
+	StoreHistogram(ctx context.Context, histogram metrics.Histogram) RepositoryError
+	RetrieveHistogram(ctx context.Context, name string, lbls labels.Labels) (metrics.Histogram, RepositoryError)
+	ListStoredHistograms(ctx context.Context, matchers ...labels.Matcher) ([]metrics.Histogram, RepositoryError)
+	WriteBulkHistograms(ctx context.Context, histograms []metrics.Histogram) error
+	// ApplyBatch writes the whole batch or, if any metric in it is invalid or
+	// the write fails, nothing of it.
+	ApplyBatch(ctx context.Context, gauges []metrics.Gauge, counters []metrics.Counter, histograms []metrics.Histogram) error
+	// ApplyBatchOnce is ApplyBatch for requests with an idempotency key. The
+	// first batch with key is applied and response is saved along with it
+	// and requestHash. Later ones within the idempotency window apply nothing
+	// and get the saved response with replayed set, or
+	// ErrorIdempotencyKeyReused if their requestHash differs.
+	ApplyBatchOnce(ctx context.Context, key string, requestHash string, response IdempotentResponse,
+		gauges []metrics.Gauge, counters []metrics.Counter, histograms []metrics.Histogram) (saved IdempotentResponse, replayed bool, err error)
+	RetrieveHistory(ctx context.Context, mType string, name string, lbls labels.Labels, from, to time.Time) ([]metrics.Sample, RepositoryError)
 }
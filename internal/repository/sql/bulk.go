@@ -0,0 +1,186 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+	"github.com/OmAsana/yapraktikum/internal/repository"
+)
+
+// Bulk upserts send a whole batch as column arrays in a single statement,
+// whatever its size.
+const (
+	bulkGaugesSelect = `SELECT n, k, l::jsonb, v
+FROM unnest($1::text[], $2::text[], $3::text[], $4::double precision[]) AS t(n, k, l, v)`
+	bulkCountersSelect = `SELECT n, k, l::jsonb, v
+FROM unnest($1::text[], $2::text[], $3::text[], $4::bigint[]) AS t(n, k, l, v)`
+
+	bulkUpsertGaugesQuery = `INSERT INTO gauges (name, labels_key, labels, value) ` + bulkGaugesSelect + `
+ON CONFLICT (name, labels_key) DO UPDATE SET value = EXCLUDED.value`
+	bulkUpsertCountersQuery = `INSERT INTO counters (name, labels_key, labels, value) ` + bulkCountersSelect + `
+ON CONFLICT (name, labels_key) DO UPDATE SET value = counters.value + EXCLUDED.value`
+
+	bulkUpsertGaugesWithHistoryQuery = `WITH upsert AS (` + bulkUpsertGaugesQuery + ` RETURNING name, labels_key, value)
+INSERT INTO metric_history (mtype, name, labels_key, ts, value) SELECT 'gauge', name, labels_key, now(), value FROM upsert`
+	bulkUpsertCountersWithHistoryQuery = `WITH upsert AS (` + bulkUpsertCountersQuery + ` RETURNING name, labels_key, value)
+INSERT INTO metric_history (mtype, name, labels_key, ts, value) SELECT 'counter', name, labels_key, now(), value::double precision FROM upsert`
+)
+
+// columns holds a batch column by column, one row per series.
+type columns struct {
+	names  []string
+	keys   []string
+	labels []string
+	index  map[string]int
+}
+
+// row returns the row of the series, adding it if it is new. Postgres
+// refuses to update a row twice in one statement, so every series must
+// appear once.
+func (c *columns) row(name string, lbls string, key string) (int, bool) {
+	seriesKey := name + "\x00" + key
+	if i, ok := c.index[seriesKey]; ok {
+		return i, false
+	}
+	if c.index == nil {
+		c.index = map[string]int{}
+	}
+	c.index[seriesKey] = len(c.names)
+	c.names = append(c.names, name)
+	c.keys = append(c.keys, key)
+	c.labels = append(c.labels, lbls)
+	return len(c.names) - 1, true
+}
+
+// gaugeColumns keeps the last value of every series.
+func gaugeColumns(gauges []metrics.Gauge) (columns, []float64) {
+	var c columns
+	values := make([]float64, 0, len(gauges))
+	for _, g := range gauges {
+		lbls, key := encodeLabels(g.Labels)
+		i, added := c.row(g.Name, lbls, key)
+		if added {
+			values = append(values, g.Value)
+			continue
+		}
+		values[i] = g.Value
+	}
+	return c, values
+}
+
+// counterColumns sums deltas of every series.
+func counterColumns(counters []metrics.Counter) (columns, []int64) {
+	var c columns
+	values := make([]int64, 0, len(counters))
+	for _, counter := range counters {
+		lbls, key := encodeLabels(counter.Labels)
+		i, added := c.row(counter.Name, lbls, key)
+		if added {
+			values = append(values, counter.Value)
+			continue
+		}
+		values[i] += counter.Value
+	}
+	return c, values
+}
+
+func (r *Repository) upsertGauges(ctx context.Context, ex execer, gauges []metrics.Gauge) error {
+	if len(gauges) == 0 {
+		return nil
+	}
+
+	query := bulkUpsertGaugesQuery
+	if r.historyRetention > 0 {
+		query = bulkUpsertGaugesWithHistoryQuery
+	}
+	c, values := gaugeColumns(gauges)
+	_, err := ex.ExecContext(ctx, query, c.names, c.keys, c.labels, values)
+	return err
+}
+
+func (r *Repository) upsertCounters(ctx context.Context, ex execer, counters []metrics.Counter) error {
+	if len(counters) == 0 {
+		return nil
+	}
+
+	query := bulkUpsertCountersQuery
+	if r.historyRetention > 0 {
+		query = bulkUpsertCountersWithHistoryQuery
+	}
+	c, values := counterColumns(counters)
+	_, err := ex.ExecContext(ctx, query, c.names, c.keys, c.labels, values)
+	return err
+}
+
+// WriteBulkGauges upserts gauges in a single statement, so either all of
+// them are written or none.
+func (r *Repository) WriteBulkGauges(ctx context.Context, gauges []metrics.Gauge) error {
+	ctx, cancel := r.bulkContext(ctx)
+	defer cancel()
+
+	return r.upsertGauges(ctx, r.db, gauges)
+}
+
+// WriteBulkCounters upserts counters in a single statement, so either all of
+// them are written or none.
+func (r *Repository) WriteBulkCounters(ctx context.Context, counters []metrics.Counter) error {
+	ctx, cancel := r.bulkContext(ctx)
+	defer cancel()
+
+	return r.upsertCounters(ctx, r.db, counters)
+}
+
+// ApplyBatch writes the whole batch in one transaction. A histogram whose
+// buckets differ from the stored ones rolls back the rest of the batch too.
+func (r *Repository) ApplyBatch(ctx context.Context, gauges []metrics.Gauge, counters []metrics.Counter, histograms []metrics.Histogram) error {
+	if err := repository.ValidateBatch(gauges, counters, histograms); err != nil {
+		return err
+	}
+
+	// A single statement is atomic on its own.
+	if len(histograms) == 0 {
+		if len(gauges) == 0 {
+			return r.WriteBulkCounters(ctx, counters)
+		}
+		if len(counters) == 0 {
+			return r.WriteBulkGauges(ctx, gauges)
+		}
+	}
+
+	ctx, cancel := r.bulkContext(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := r.applyBatch(ctx, tx, gauges, counters, histograms); err != nil {
+		return rollback(tx, err)
+	}
+	return tx.Commit()
+}
+
+func (r *Repository) applyBatch(ctx context.Context, ex execer, gauges []metrics.Gauge, counters []metrics.Counter, histograms []metrics.Histogram) error {
+	if err := r.upsertGauges(ctx, ex, gauges); err != nil {
+		return err
+	}
+	if err := r.upsertCounters(ctx, ex, counters); err != nil {
+		return err
+	}
+	for _, h := range histograms {
+		if err := r.storeHistogram(ctx, ex, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollback aborts tx after err and returns err, noting a failed rollback.
+func rollback(tx *sql.Tx, err error) error {
+	if rerr := tx.Rollback(); rerr != nil {
+		return fmt.Errorf("%w, rollback failed: %s", err, rerr)
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package sql
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/OmAsana/yapraktikum/internal/labels"
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+)
+
+func Test_gaugeColumns(t *testing.T) {
+	c, values := gaugeColumns([]metrics.Gauge{
+		{Name: "Alloc", Value: 1},
+		{Name: "Alloc", Value: 2, Labels: labels.Labels{"host": "a"}},
+		{Name: "Alloc", Value: 3},
+	})
+	assert.Equal(t, []string{"Alloc", "Alloc"}, c.names)
+	assert.Equal(t, []string{"", `{host="a"}`}, c.keys)
+	assert.Equal(t, []string{"{}", `{"host":"a"}`}, c.labels)
+	assert.Equal(t, []float64{3, 2}, values)
+}
+
+func Test_counterColumns(t *testing.T) {
+	c, values := counterColumns([]metrics.Counter{
+		{Name: "PollCount", Value: 1},
+		{Name: "Other", Value: 5},
+		{Name: "PollCount", Value: 2},
+	})
+	assert.Equal(t, []string{"PollCount", "Other"}, c.names)
+	assert.Equal(t, []int64{3, 5}, values)
+}
+
+// benchRepository connects to TEST_DATABASE_DSN and clears its tables.
+// Never point it at a database holding real data.
+func benchRepository(b *testing.B) *Repository {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		b.Skip("TEST_DATABASE_DSN is not set")
+	}
+	r, err := NewRepository(dsn, false, WithBulkTimeout(0))
+	require.NoError(b, err)
+	b.Cleanup(func() { r.db.Close() })
+	return r
+}
+
+func benchBatch(size int) ([]metrics.Gauge, []metrics.Counter) {
+	gauges := make([]metrics.Gauge, size)
+	counters := make([]metrics.Counter, size)
+	for i := 0; i < size; i++ {
+		name := fmt.Sprintf("metric_%d", i)
+		gauges[i] = metrics.Gauge{Name: name, Value: float64(i), Labels: labels.Labels{"host": "bench"}}
+		counters[i] = metrics.Counter{Name: name, Value: int64(i), Labels: labels.Labels{"host": "bench"}}
+	}
+	return gauges, counters
+}
+
+// writePerRow is how batches used to be written: a statement per metric.
+func writePerRow(ctx context.Context, r *Repository, gauges []metrics.Gauge, counters []metrics.Counter) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, g := range gauges {
+		lbls, key := encodeLabels(g.Labels)
+		if _, err := tx.ExecContext(ctx, r.gaugeQuery(), g.Name, key, lbls, g.Value); err != nil {
+			return rollback(tx, err)
+		}
+	}
+	for _, c := range counters {
+		lbls, key := encodeLabels(c.Labels)
+		if _, err := tx.ExecContext(ctx, r.counterQuery(), c.Name, key, lbls, c.Value); err != nil {
+			return rollback(tx, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func BenchmarkRepository_ApplyBatch(b *testing.B) {
+	r := benchRepository(b)
+	ctx := context.Background()
+
+	for _, size := range []int{10, 100, 1000} {
+		gauges, counters := benchBatch(size)
+
+		b.Run(fmt.Sprintf("per_row/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, writePerRow(ctx, r, gauges, counters))
+			}
+		})
+		b.Run(fmt.Sprintf("multi_row/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, r.ApplyBatch(ctx, gauges, counters, nil))
+			}
+		})
+	}
+}
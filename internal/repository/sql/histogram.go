@@ -0,0 +1,178 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jackc/pgtype"
+
+	"github.com/OmAsana/yapraktikum/internal/labels"
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+	"github.com/OmAsana/yapraktikum/internal/repository"
+)
+
+// upsertHistogramQuery adds bucket counts element-wise. The update is
+// skipped when stored buckets differ, which surfaces as zero affected rows.
+const upsertHistogramQuery = `INSERT INTO histograms (name, labels_key, labels, buckets, counts, sum, count)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (name, labels_key) DO UPDATE SET
+	counts = (SELECT array_agg(a + b ORDER BY i) FROM unnest(histograms.counts, EXCLUDED.counts) WITH ORDINALITY AS t(a, b, i)),
+	sum = histograms.sum + EXCLUDED.sum,
+	count = histograms.count + EXCLUDED.count
+WHERE histograms.buckets = EXCLUDED.buckets`
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+type Histogram struct {
+	Name    string
+	Labels  []byte
+	Buckets pgtype.Float8Array
+	Counts  pgtype.Int8Array
+	Sum     float64
+	Count   int64
+}
+
+func (h Histogram) ToMetric() (metrics.Histogram, error) {
+	m := metrics.Histogram{
+		Name:   h.Name,
+		Sum:    h.Sum,
+		Count:  uint64(h.Count),
+		Labels: decodeLabels(h.Labels),
+	}
+	if err := h.Buckets.AssignTo(&m.Buckets); err != nil {
+		return m, err
+	}
+	var counts []int64
+	if err := h.Counts.AssignTo(&counts); err != nil {
+		return m, err
+	}
+	m.Counts = make([]uint64, len(counts))
+	for i, c := range counts {
+		m.Counts[i] = uint64(c)
+	}
+	return m, nil
+}
+
+func (r *Repository) storeHistogram(ctx context.Context, ex execer, histogram metrics.Histogram) error {
+	if err := histogram.IsValid(); err != nil {
+		return repository.ErrorHistogramIsNotValid
+	}
+
+	counts := make([]int64, len(histogram.Counts))
+	for i, c := range histogram.Counts {
+		counts[i] = int64(c)
+	}
+	buckets := histogram.Buckets
+	if buckets == nil {
+		buckets = []float64{}
+	}
+
+	lbls, key := encodeLabels(histogram.Labels)
+	res, err := ex.ExecContext(ctx, upsertHistogramQuery,
+		histogram.Name, key, lbls, buckets, counts, histogram.Sum, int64(histogram.Count))
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return repository.ErrorHistogramBucketsMismatch
+	}
+	return nil
+}
+
+func (r *Repository) StoreHistogram(ctx context.Context, histogram metrics.Histogram) repository.RepositoryError {
+	ctx, cancel := r.queryContext(ctx)
+	defer cancel()
+
+	err := r.storeHistogram(ctx, r.db, histogram)
+	switch {
+	case errors.Is(err, repository.ErrorHistogramIsNotValid), errors.Is(err, repository.ErrorHistogramBucketsMismatch):
+		return err
+	case err != nil:
+		r.log.S().Errorf("Could not insert histogram: %s", err)
+		return repository.ErrorInternalError
+	}
+	return nil
+}
+
+func (r *Repository) WriteBulkHistograms(ctx context.Context, histograms []metrics.Histogram) error {
+	ctx, cancel := r.bulkContext(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range histograms {
+		if err := r.storeHistogram(ctx, tx, h); err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				r.log.S().Errorf("Could not rollback: %s", rerr)
+			}
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *Repository) RetrieveHistogram(ctx context.Context, name string, lbls labels.Labels) (metrics.Histogram, repository.RepositoryError) {
+	ctx, cancel := r.queryContext(ctx)
+	defer cancel()
+	sqlStatement := `SELECT name, labels, buckets, counts, sum, count FROM histograms WHERE name=$1 AND labels_key=$2`
+	_, key := encodeLabels(lbls)
+	h := Histogram{}
+	err := r.db.QueryRowContext(ctx, sqlStatement, name, key).Scan(&h.Name, &h.Labels, &h.Buckets, &h.Counts, &h.Sum, &h.Count)
+	switch {
+	case err == sql.ErrNoRows:
+		return metrics.Histogram{}, repository.ErrorHistogramNotFound
+	case err != nil:
+		r.log.S().Errorf("could not retrieve histogram: %s", err)
+		return metrics.Histogram{}, repository.ErrorHistogramNotFound
+	}
+
+	m, err := h.ToMetric()
+	if err != nil {
+		r.log.S().Errorf("could not decode histogram: %s", err)
+		return metrics.Histogram{}, repository.ErrorInternalError
+	}
+	return m, nil
+}
+
+func (r *Repository) ListStoredHistograms(ctx context.Context, matchers ...labels.Matcher) ([]metrics.Histogram, repository.RepositoryError) {
+	ctx, cancel := r.queryContext(ctx)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `SELECT name, labels, buckets, counts, sum, count FROM histograms`)
+	if err != nil {
+		r.log.S().Errorf("Error retriving histograms: %s", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var histograms []metrics.Histogram
+	for rows.Next() {
+		var h Histogram
+		if err := rows.Scan(&h.Name, &h.Labels, &h.Buckets, &h.Counts, &h.Sum, &h.Count); err != nil {
+			return nil, err
+		}
+		m, err := h.ToMetric()
+		if err != nil {
+			return nil, err
+		}
+		if labels.MatchAll(m.Labels, matchers) {
+			histograms = append(histograms, m)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return histograms, nil
+}
@@ -0,0 +1,108 @@
+package sql
+
+import (
+	"context"
+	"time"
+
+	"github.com/OmAsana/yapraktikum/internal/labels"
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+	"github.com/OmAsana/yapraktikum/internal/repository"
+)
+
+// With history enabled every upsert also appends the resulting value to
+// metric_history in the same statement.
+const (
+	upsertCounterWithHistoryQuery = `WITH upsert AS (` + upsertCounterQuery + ` RETURNING value)
+INSERT INTO metric_history (mtype, name, labels_key, ts, value) SELECT 'counter', $1, $2, now(), value::double precision FROM upsert`
+	upsertGaugeWithHistoryQuery = `WITH upsert AS (` + upsertGaugeQuery + ` RETURNING value)
+INSERT INTO metric_history (mtype, name, labels_key, ts, value) SELECT 'gauge', $1, $2, now(), value FROM upsert`
+)
+
+func (r *Repository) counterQuery() string {
+	if r.historyRetention > 0 {
+		return upsertCounterWithHistoryQuery
+	}
+	return upsertCounterQuery
+}
+
+func (r *Repository) gaugeQuery() string {
+	if r.historyRetention > 0 {
+		return upsertGaugeWithHistoryQuery
+	}
+	return upsertGaugeQuery
+}
+
+func (r *Repository) RetrieveHistory(ctx context.Context, mType string, name string, lbls labels.Labels, from, to time.Time) ([]metrics.Sample, repository.RepositoryError) {
+	if r.historyRetention <= 0 {
+		return nil, repository.ErrorHistoryDisabled
+	}
+
+	if oldest := time.Now().Add(-r.historyRetention); from.Before(oldest) {
+		from = oldest
+	}
+
+	ctx, cancel := r.queryContext(ctx)
+	defer cancel()
+
+	_, key := encodeLabels(lbls)
+	sqlStatement := `SELECT ts, value FROM metric_history
+WHERE mtype=$1 AND name=$2 AND labels_key=$3 AND ts BETWEEN $4 AND $5 ORDER BY ts`
+	rows, err := r.db.QueryContext(ctx, sqlStatement, mType, name, key, from, to)
+	if err != nil {
+		r.log.S().Errorf("could not retrieve history: %s", err)
+		return nil, repository.ErrorInternalError
+	}
+	defer rows.Close()
+
+	var samples []metrics.Sample
+	for rows.Next() {
+		var s metrics.Sample
+		if err := rows.Scan(&s.Timestamp, &s.Value); err != nil {
+			r.log.S().Errorf("could not scan history: %s", err)
+			return nil, repository.ErrorInternalError
+		}
+		samples = append(samples, s)
+	}
+	if err := rows.Err(); err != nil {
+		r.log.S().Errorf("could not retrieve history: %s", err)
+		return nil, repository.ErrorInternalError
+	}
+
+	if len(samples) == 0 {
+		var exists bool
+		err := r.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM metric_history WHERE mtype=$1 AND name=$2 AND labels_key=$3)`,
+			mType, name, key).Scan(&exists)
+		if err != nil {
+			r.log.S().Errorf("could not retrieve history: %s", err)
+			return nil, repository.ErrorInternalError
+		}
+		if !exists {
+			return nil, repository.ErrorHistoryNotFound
+		}
+	}
+	return samples, nil
+}
+
+func (r *Repository) pruneHistoryRoutine() {
+	interval := r.historyRetention / 10
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			r.pruneHistory()
+		}
+	}()
+}
+
+func (r *Repository) pruneHistory() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, "DELETE FROM metric_history WHERE ts < $1", time.Now().Add(-r.historyRetention))
+	if err != nil {
+		r.log.S().Errorf("could not prune history: %s", err)
+	}
+}
@@ -0,0 +1,107 @@
+package sql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+	"github.com/OmAsana/yapraktikum/internal/repository"
+)
+
+// claimIdempotencyKeyQuery saves a response unless a live one is saved
+// already, which surfaces as zero affected rows. A concurrent request with
+// the same key waits on the primary key until the first one is done.
+const claimIdempotencyKeyQuery = `INSERT INTO idempotency_keys (key, request_hash, status, body, created_at) VALUES ($1, $2, $3, $4, now())
+ON CONFLICT (key) DO UPDATE SET request_hash = EXCLUDED.request_hash, status = EXCLUDED.status, body = EXCLUDED.body, created_at = EXCLUDED.created_at
+WHERE idempotency_keys.created_at < now() - $5::interval`
+
+// ApplyBatchOnce claims key and applies the batch in one transaction, so the
+// key is saved only with the batch it belongs to.
+func (r *Repository) ApplyBatchOnce(ctx context.Context, key string, requestHash string, response repository.IdempotentResponse,
+	gauges []metrics.Gauge, counters []metrics.Counter, histograms []metrics.Histogram) (repository.IdempotentResponse, bool, error) {
+	if r.idempotencyWindow <= 0 {
+		return response, false, r.ApplyBatch(ctx, gauges, counters, histograms)
+	}
+	if err := repository.ValidateBatch(gauges, counters, histograms); err != nil {
+		return response, false, err
+	}
+
+	ctx, cancel := r.bulkContext(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return response, false, err
+	}
+
+	body := response.Body
+	if body == nil {
+		body = []byte{}
+	}
+	res, err := tx.ExecContext(ctx, claimIdempotencyKeyQuery, key, requestHash, response.StatusCode, body, pgInterval(r.idempotencyWindow))
+	if err != nil {
+		return response, false, rollback(tx, err)
+	}
+	claimed, err := res.RowsAffected()
+	if err != nil {
+		return response, false, rollback(tx, err)
+	}
+	if claimed == 0 {
+		if err := tx.Rollback(); err != nil {
+			return response, false, err
+		}
+		saved, savedHash, err := r.savedResponse(ctx, key)
+		if err != nil {
+			return response, false, err
+		}
+		// Keys saved before request hashes were stored match any request.
+		if savedHash != "" && savedHash != requestHash {
+			return response, false, repository.ErrorIdempotencyKeyReused
+		}
+		return saved, true, nil
+	}
+
+	if err := r.applyBatch(ctx, tx, gauges, counters, histograms); err != nil {
+		return response, false, rollback(tx, err)
+	}
+	return response, false, tx.Commit()
+}
+
+func (r *Repository) savedResponse(ctx context.Context, key string) (repository.IdempotentResponse, string, error) {
+	var saved repository.IdempotentResponse
+	var requestHash string
+	err := r.db.QueryRowContext(ctx, `SELECT status, body, request_hash FROM idempotency_keys WHERE key=$1`, key).
+		Scan(&saved.StatusCode, &saved.Body, &requestHash)
+	return saved, requestHash, err
+}
+
+func (r *Repository) pruneIdempotencyKeysRoutine() {
+	interval := r.idempotencyWindow / 10
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			r.pruneIdempotencyKeys()
+		}
+	}()
+}
+
+func (r *Repository) pruneIdempotencyKeys() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Keys are saved with the database clock, so they expire by it too.
+	_, err := r.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE created_at < now() - $1::interval", pgInterval(r.idempotencyWindow))
+	if err != nil {
+		r.log.S().Errorf("could not prune idempotency keys: %s", err)
+	}
+}
+
+// pgInterval formats d as a PostgreSQL interval.
+func pgInterval(d time.Duration) string {
+	return fmt.Sprintf("%d microseconds", d.Microseconds())
+}
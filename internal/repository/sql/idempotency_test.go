@@ -0,0 +1,13 @@
+package sql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_pgInterval(t *testing.T) {
+	assert.Equal(t, "3600000000 microseconds", pgInterval(time.Hour))
+	assert.Equal(t, "1500 microseconds", pgInterval(1500*time.Microsecond))
+}
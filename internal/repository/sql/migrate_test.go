@@ -0,0 +1,93 @@
+package sql
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations_Embedded(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	assert.Equal(t, "init", migrations[0].Name)
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version)
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	file := func(body string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(body)}
+	}
+
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		want    []migration
+		wantErr bool
+	}{
+		{
+			name: "ordered by version",
+			files: fstest.MapFS{
+				"migrations/0002_second.up.sql":   file("up 2"),
+				"migrations/0002_second.down.sql": file("down 2"),
+				"migrations/0001_first.up.sql":    file("up 1"),
+				"migrations/0001_first.down.sql":  file("down 1"),
+			},
+			want: []migration{
+				{Version: 1, Name: "first", Up: "up 1", Down: "down 1"},
+				{Version: 2, Name: "second", Up: "up 2", Down: "down 2"},
+			},
+		},
+		{
+			name: "missing down",
+			files: fstest.MapFS{
+				"migrations/0001_first.up.sql": file("up 1"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "gap in versions",
+			files: fstest.MapFS{
+				"migrations/0001_first.up.sql":   file("up 1"),
+				"migrations/0001_first.down.sql": file("down 1"),
+				"migrations/0003_third.up.sql":   file("up 3"),
+				"migrations/0003_third.down.sql": file("down 3"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "names differ",
+			files: fstest.MapFS{
+				"migrations/0001_first.up.sql":   file("up 1"),
+				"migrations/0001_other.down.sql": file("down 1"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "unexpected file",
+			files: fstest.MapFS{
+				"migrations/0001_first.up.sql":   file("up 1"),
+				"migrations/0001_first.down.sql": file("down 1"),
+				"migrations/README":              file("notes"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadMigrations(tt.files)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
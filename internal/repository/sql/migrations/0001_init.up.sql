@@ -0,0 +1,19 @@
+-- Tables as created before versioned migrations. Every statement is
+-- idempotent, so databases set up by older servers are adopted as they are.
+CREATE TABLE IF NOT EXISTS gauges ( name varchar(40) NOT NULL, value double precision NOT NULL);
+ALTER TABLE gauges ADD COLUMN IF NOT EXISTS labels_key text NOT NULL DEFAULT '';
+ALTER TABLE gauges ADD COLUMN IF NOT EXISTS labels jsonb NOT NULL DEFAULT '{}';
+ALTER TABLE gauges DROP CONSTRAINT IF EXISTS gauges_pkey;
+CREATE UNIQUE INDEX IF NOT EXISTS gauges_series_idx ON gauges (name, labels_key);
+
+CREATE TABLE IF NOT EXISTS counters ( name varchar(40) NOT NULL, value numeric NOT NULL);
+ALTER TABLE counters ADD COLUMN IF NOT EXISTS labels_key text NOT NULL DEFAULT '';
+ALTER TABLE counters ADD COLUMN IF NOT EXISTS labels jsonb NOT NULL DEFAULT '{}';
+ALTER TABLE counters DROP CONSTRAINT IF EXISTS counters_pkey;
+CREATE UNIQUE INDEX IF NOT EXISTS counters_series_idx ON counters (name, labels_key);
+
+CREATE TABLE IF NOT EXISTS histograms ( name varchar(40) NOT NULL, labels_key text NOT NULL DEFAULT '', labels jsonb NOT NULL DEFAULT '{}', buckets double precision[] NOT NULL, counts bigint[] NOT NULL, sum double precision NOT NULL, count bigint NOT NULL);
+CREATE UNIQUE INDEX IF NOT EXISTS histograms_series_idx ON histograms (name, labels_key);
+
+CREATE TABLE IF NOT EXISTS metric_history ( mtype varchar(16) NOT NULL, name varchar(40) NOT NULL, labels_key text NOT NULL DEFAULT '', ts timestamptz NOT NULL, value double precision NOT NULL);
+CREATE INDEX IF NOT EXISTS metric_history_series_ts_idx ON metric_history (mtype, name, labels_key, ts);
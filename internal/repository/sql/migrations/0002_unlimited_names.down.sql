@@ -0,0 +1,5 @@
+-- Fails rather than truncates if longer names were stored meanwhile.
+ALTER TABLE metric_history ALTER COLUMN name TYPE varchar(40);
+ALTER TABLE histograms ALTER COLUMN name TYPE varchar(40);
+ALTER TABLE counters ALTER COLUMN name TYPE varchar(40);
+ALTER TABLE gauges ALTER COLUMN name TYPE varchar(40);
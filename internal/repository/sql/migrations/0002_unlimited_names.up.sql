@@ -0,0 +1,5 @@
+-- Metric names are no longer limited to 40 characters.
+ALTER TABLE gauges ALTER COLUMN name TYPE text;
+ALTER TABLE counters ALTER COLUMN name TYPE text;
+ALTER TABLE histograms ALTER COLUMN name TYPE text;
+ALTER TABLE metric_history ALTER COLUMN name TYPE text;
@@ -0,0 +1,3 @@
+-- Responses to update requests by their Idempotency-Key header.
+CREATE TABLE idempotency_keys ( key text PRIMARY KEY, status integer NOT NULL, body bytea NOT NULL, created_at timestamptz NOT NULL DEFAULT now());
+CREATE INDEX idempotency_keys_created_at_idx ON idempotency_keys (created_at);
@@ -0,0 +1,2 @@
+-- Keys reused with a different request are refused rather than replayed.
+ALTER TABLE idempotency_keys ADD COLUMN request_hash text NOT NULL DEFAULT '';
@@ -1,6 +1,10 @@
 package sql
 
-import "github.com/OmAsana/yapraktikum/internal/logging"
+import (
+	"time"
+
+	"github.com/OmAsana/yapraktikum/internal/logging"
+)
 
 type Option func(*Repository) error
 
@@ -10,3 +14,39 @@ func WithLogger(l *logging.Logger) Option {
 		return nil
 	}
 }
+
+// WithHistory records every write into metric_history and prunes samples
+// older than retention. History is disabled when retention is zero.
+func WithHistory(retention time.Duration) Option {
+	return func(repository *Repository) error {
+		repository.historyRetention = retention
+		return nil
+	}
+}
+
+// WithQueryTimeout limits every single query. Zero disables the limit, then
+// queries run until the caller's context is done.
+func WithQueryTimeout(timeout time.Duration) Option {
+	return func(repository *Repository) error {
+		repository.queryTimeout = timeout
+		return nil
+	}
+}
+
+// WithBulkTimeout limits bulk write transactions, as well as clearing the
+// tables on start without restore. Zero disables the limit.
+func WithBulkTimeout(timeout time.Duration) Option {
+	return func(repository *Repository) error {
+		repository.bulkTimeout = timeout
+		return nil
+	}
+}
+
+// WithIdempotencyWindow remembers idempotency keys for window. Keys are
+// ignored when window is zero.
+func WithIdempotencyWindow(window time.Duration) Option {
+	return func(repository *Repository) error {
+		repository.idempotencyWindow = window
+		return nil
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	_ "github.com/jackc/pgx/v4/stdlib"
 
+	"github.com/OmAsana/yapraktikum/internal/labels"
 	"github.com/OmAsana/yapraktikum/internal/logging"
 	"github.com/OmAsana/yapraktikum/internal/metrics"
 	"github.com/OmAsana/yapraktikum/internal/repository"
@@ -14,9 +15,26 @@ import (
 
 var _ repository.MetricsRepository = (*Repository)(nil)
 
+const (
+	upsertCounterQuery = `INSERT INTO counters (name, labels_key, labels, value) VALUES ($1, $2, $3, $4)
+ON CONFLICT (name, labels_key) DO UPDATE SET value = counters.value + EXCLUDED.value`
+	upsertGaugeQuery = `INSERT INTO gauges (name, labels_key, labels, value) VALUES ($1, $2, $3, $4)
+ON CONFLICT (name, labels_key) DO UPDATE SET value = EXCLUDED.value`
+)
+
+var (
+	DefaultQueryTimeout = 1 * time.Second
+	DefaultBulkTimeout  = 5 * time.Second
+)
+
 type Repository struct {
 	db  *sql.DB
 	log *logging.Logger
+
+	queryTimeout      time.Duration
+	bulkTimeout       time.Duration
+	historyRetention  time.Duration
+	idempotencyWindow time.Duration
 }
 
 func NewRepository(dbn string, restore bool, opts ...Option) (*Repository, error) {
@@ -25,8 +43,11 @@ func NewRepository(dbn string, restore bool, opts ...Option) (*Repository, error
 		return nil, err
 	}
 	r := &Repository{
-		db:  db,
-		log: logging.NewNoop(),
+		db:                db,
+		log:               logging.NewNoop(),
+		queryTimeout:      DefaultQueryTimeout,
+		bulkTimeout:       DefaultBulkTimeout,
+		idempotencyWindow: repository.DefaultIdempotencyWindow,
 	}
 
 	for _, opt := range opts {
@@ -35,102 +56,61 @@ func NewRepository(dbn string, restore bool, opts ...Option) (*Repository, error
 		}
 	}
 
-	if !restore {
-		if err := r.dropDatabase(); err != nil {
-			return nil, err
-		}
-	}
-
-	if err := r.initTable(); err != nil {
-		return nil, err
-	}
-
-	return r, nil
-}
-
-func (r *Repository) WriteBulkCounters(counters []metrics.Counter) error {
-	tx, err := r.db.Begin()
+	migrator, err := newMigrator(db, r.log)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	stmt, err := tx.Prepare("INSERT INTO counters (name, value) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET value = counters.value + EXCLUDED.value")
-
-	if err != nil {
-		return err
+	if _, err := migrator.Up(context.Background()); err != nil {
+		return nil, err
 	}
 
-	for _, v := range counters {
-		if _, err := stmt.Exec(v.Name, v.Value); err != nil {
-			if err = tx.Rollback(); err != nil {
-				return err
-			}
+	if !restore {
+		if err := r.truncate(); err != nil {
+			return nil, err
 		}
 	}
 
-	if err := tx.Commit(); err != nil {
-		return err
+	if r.historyRetention > 0 {
+		r.pruneHistoryRoutine()
 	}
-	return nil
-}
-
-func (r *Repository) WriteBulkGauges(gauges []metrics.Gauge) error {
-	tx, err := r.db.Begin()
-	if err != nil {
-		return err
+	if r.idempotencyWindow > 0 {
+		r.pruneIdempotencyKeysRoutine()
 	}
 
-	stmt, err := tx.Prepare("INSERT INTO gauges (name, value) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE set VALUE = EXCLUDED.value")
-
-	if err != nil {
-		return err
-	}
+	return r, nil
+}
 
-	for _, v := range gauges {
-		if _, err := stmt.Exec(v.Name, v.Value); err != nil {
-			if err = tx.Rollback(); err != nil {
-				return err
-			}
-		}
+// queryContext limits a single query to the configured timeout. A zero
+// timeout leaves the deadline to the caller.
+func (r *Repository) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, r.queryTimeout)
+}
 
-	if err := tx.Commit(); err != nil {
-		return err
+// bulkContext limits a bulk write transaction to the configured timeout.
+func (r *Repository) bulkContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.bulkTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
-
-	return nil
+	return context.WithTimeout(ctx, r.bulkTimeout)
 }
 
-func (r *Repository) initTable() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+func (r *Repository) Ping(ctx context.Context) bool {
+	ctx, cancel := r.queryContext(ctx)
 	defer cancel()
-	_, err := r.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS gauges ( name varchar(40) PRIMARY KEY, value double precision NOT NULL)")
-	if err != nil {
-		return err
-	}
-
-	_, err = r.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS counters ( name varchar(40) PRIMARY KEY, value numeric NOT NULL)")
-	if err != nil {
-		return err
-	}
 
-	return nil
-}
-
-func (r *Repository) Ping() bool {
-	err := r.db.Ping()
+	err := r.db.PingContext(ctx)
 	return err == nil
 }
 
-func (r *Repository) StoreCounter(counter metrics.Counter) repository.RepositoryError {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+func (r *Repository) StoreCounter(ctx context.Context, counter metrics.Counter) repository.RepositoryError {
+	ctx, cancel := r.queryContext(ctx)
 	defer cancel()
 
-	c := Counter{
-		Name:  counter.Name,
-		Value: counter.Value,
-	}
-	_, err := r.db.ExecContext(ctx, "INSERT INTO counters (name, value) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET value = counters.value + EXCLUDED.value", c.Name, c.Value)
+	lbls, key := encodeLabels(counter.Labels)
+	_, err := r.db.ExecContext(ctx, r.counterQuery(), counter.Name, key, lbls, counter.Value)
 	if err != nil {
 		r.log.S().Errorf("Could not insert counter: %s", err)
 		return repository.ErrorInternalError
@@ -138,12 +118,13 @@ func (r *Repository) StoreCounter(counter metrics.Counter) repository.Repository
 	return nil
 }
 
-func (r *Repository) RetrieveCounter(name string) (metrics.Counter, repository.RepositoryError) {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+func (r *Repository) RetrieveCounter(ctx context.Context, name string, lbls labels.Labels) (metrics.Counter, repository.RepositoryError) {
+	ctx, cancel := r.queryContext(ctx)
 	defer cancel()
-	sqlStatement := `SELECT name, value from counters where name=$1`
+	sqlStatement := `SELECT name, labels, value from counters where name=$1 and labels_key=$2`
+	_, key := encodeLabels(lbls)
 	c := Counter{}
-	err := r.db.QueryRowContext(ctx, sqlStatement, name).Scan(&c.Name, &c.Value)
+	err := r.db.QueryRowContext(ctx, sqlStatement, name, key).Scan(&c.Name, &c.Labels, &c.Value)
 	switch {
 	case err == sql.ErrNoRows:
 		r.log.S().Info("Counter does not exits: ", name)
@@ -158,15 +139,12 @@ func (r *Repository) RetrieveCounter(name string) (metrics.Counter, repository.R
 
 }
 
-func (r *Repository) StoreGauge(gauge metrics.Gauge) repository.RepositoryError {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+func (r *Repository) StoreGauge(ctx context.Context, gauge metrics.Gauge) repository.RepositoryError {
+	ctx, cancel := r.queryContext(ctx)
 	defer cancel()
 
-	g := Gauge{
-		Name:  gauge.Name,
-		Delta: gauge.Value,
-	}
-	_, err := r.db.ExecContext(ctx, "INSERT INTO gauges (name, value) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE set VALUE = EXCLUDED.value", g.Name, g.Delta)
+	lbls, key := encodeLabels(gauge.Labels)
+	_, err := r.db.ExecContext(ctx, r.gaugeQuery(), gauge.Name, key, lbls, gauge.Value)
 	if err != nil {
 		r.log.S().Errorf("Could not insert gauge: %s", err)
 		return repository.ErrorInternalError
@@ -174,12 +152,13 @@ func (r *Repository) StoreGauge(gauge metrics.Gauge) repository.RepositoryError
 	return nil
 }
 
-func (r *Repository) RetrieveGauge(name string) (metrics.Gauge, repository.RepositoryError) {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+func (r *Repository) RetrieveGauge(ctx context.Context, name string, lbls labels.Labels) (metrics.Gauge, repository.RepositoryError) {
+	ctx, cancel := r.queryContext(ctx)
 	defer cancel()
-	sqlStatement := `SELECT name, value from gauges where name=$1`
+	sqlStatement := `SELECT name, labels, value from gauges where name=$1 and labels_key=$2`
+	_, key := encodeLabels(lbls)
 	g := Gauge{}
-	err := r.db.QueryRowContext(ctx, sqlStatement, name).Scan(&g.Name, &g.Delta)
+	err := r.db.QueryRowContext(ctx, sqlStatement, name, key).Scan(&g.Name, &g.Labels, &g.Delta)
 	switch {
 	case err == sql.ErrNoRows:
 		r.log.S().Info("Counter does not exits: ", name)
@@ -193,17 +172,17 @@ func (r *Repository) RetrieveGauge(name string) (metrics.Gauge, repository.Repos
 	}
 }
 
-func (r *Repository) ListStoredMetrics() ([]metrics.Gauge, []metrics.Counter, repository.RepositoryError) {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+func (r *Repository) ListStoredMetrics(ctx context.Context, matchers ...labels.Matcher) ([]metrics.Gauge, []metrics.Counter, repository.RepositoryError) {
+	ctx, cancel := r.queryContext(ctx)
 	defer cancel()
 
-	gauges, err := r.retrieveGauges(ctx)
+	gauges, err := r.retrieveGauges(ctx, matchers)
 	if err != nil {
 		r.log.S().Errorf("Error retriving gauges: %s", err)
 		return nil, nil, err
 	}
 
-	counters, err := r.retrieveCounters(ctx)
+	counters, err := r.retrieveCounters(ctx, matchers)
 	if err != nil {
 		r.log.S().Errorf("Error retriving counters: %s", err)
 		return nil, nil, err
@@ -212,9 +191,9 @@ func (r *Repository) ListStoredMetrics() ([]metrics.Gauge, []metrics.Counter, re
 	return gauges, counters, nil
 }
 
-func (r Repository) retrieveCounters(ctx context.Context) ([]metrics.Counter, error) {
+func (r Repository) retrieveCounters(ctx context.Context, matchers []labels.Matcher) ([]metrics.Counter, error) {
 	var counters []metrics.Counter
-	sqlStatement := `SELECT * FROM counters`
+	sqlStatement := `SELECT name, labels, value FROM counters`
 
 	rows, err := r.db.QueryContext(ctx, sqlStatement)
 	if err != nil {
@@ -223,10 +202,13 @@ func (r Repository) retrieveCounters(ctx context.Context) ([]metrics.Counter, er
 	defer rows.Close()
 	for rows.Next() {
 		var counter Counter
-		if err := rows.Scan(&counter.Name, &counter.Value); err != nil {
+		if err := rows.Scan(&counter.Name, &counter.Labels, &counter.Value); err != nil {
 			return nil, err
 		}
-		counters = append(counters, counter.ToMetric())
+		m := counter.ToMetric()
+		if labels.MatchAll(m.Labels, matchers) {
+			counters = append(counters, m)
+		}
 	}
 
 	rerr := rows.Close()
@@ -240,9 +222,9 @@ func (r Repository) retrieveCounters(ctx context.Context) ([]metrics.Counter, er
 
 	return counters, nil
 }
-func (r Repository) retrieveGauges(ctx context.Context) ([]metrics.Gauge, error) {
+func (r Repository) retrieveGauges(ctx context.Context, matchers []labels.Matcher) ([]metrics.Gauge, error) {
 	var gauges []metrics.Gauge
-	sqlStatement := `SELECT * FROM gauges`
+	sqlStatement := `SELECT name, labels, value FROM gauges`
 
 	rows, err := r.db.QueryContext(ctx, sqlStatement)
 	if err != nil {
@@ -251,10 +233,13 @@ func (r Repository) retrieveGauges(ctx context.Context) ([]metrics.Gauge, error)
 	defer rows.Close()
 	for rows.Next() {
 		var gauge Gauge
-		if err := rows.Scan(&gauge.Name, &gauge.Delta); err != nil {
+		if err := rows.Scan(&gauge.Name, &gauge.Labels, &gauge.Delta); err != nil {
 			return nil, err
 		}
-		gauges = append(gauges, gauge.ToMetric())
+		m := gauge.ToMetric()
+		if labels.MatchAll(m.Labels, matchers) {
+			gauges = append(gauges, m)
+		}
 
 	}
 
@@ -270,12 +255,11 @@ func (r Repository) retrieveGauges(ctx context.Context) ([]metrics.Gauge, error)
 	return gauges, nil
 }
 
-func (r *Repository) dropDatabase() error {
-	sqlStatement := `DROP TABLE IF EXISTS counters, gauges CASCADE`
-	_, err := r.db.Exec(sqlStatement)
-	if err != nil {
-		return err
-	}
+// truncate deletes stored metrics but keeps the schema.
+func (r *Repository) truncate() error {
+	ctx, cancel := r.bulkContext(context.Background())
+	defer cancel()
 
-	return nil
+	_, err := r.db.ExecContext(ctx, `TRUNCATE counters, gauges, histograms, metric_history, idempotency_keys`)
+	return err
 }
@@ -1,27 +1,61 @@
 package sql
 
-import "github.com/OmAsana/yapraktikum/internal/metrics"
+import (
+	"encoding/json"
+
+	"github.com/OmAsana/yapraktikum/internal/labels"
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+)
 
 type Counter struct {
-	Name  string
-	Value int64
+	Name   string
+	Value  int64
+	Labels []byte
 }
 
 func (c Counter) ToMetric() metrics.Counter {
 	return metrics.Counter{
-		Name:  c.Name,
-		Value: c.Value,
+		Name:   c.Name,
+		Value:  c.Value,
+		Labels: decodeLabels(c.Labels),
 	}
 }
 
 type Gauge struct {
-	Name  string
-	Delta float64
+	Name   string
+	Delta  float64
+	Labels []byte
 }
 
 func (g Gauge) ToMetric() metrics.Gauge {
 	return metrics.Gauge{
-		Name:  g.Name,
-		Value: g.Delta,
+		Name:   g.Name,
+		Value:  g.Delta,
+		Labels: decodeLabels(g.Labels),
+	}
+}
+
+// encodeLabels returns the jsonb value and the canonical key the series is
+// unique on.
+func encodeLabels(l labels.Labels) (string, string) {
+	l = labels.FromMap(l)
+	if l == nil {
+		return "{}", ""
+	}
+	out, err := json.Marshal(l)
+	if err != nil {
+		return "{}", ""
+	}
+	return string(out), l.String()
+}
+
+func decodeLabels(raw []byte) labels.Labels {
+	if len(raw) == 0 {
+		return nil
+	}
+	var l labels.Labels
+	if err := json.Unmarshal(raw, &l); err != nil {
+		return nil
 	}
+	return labels.FromMap(l)
 }
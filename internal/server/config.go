@@ -2,10 +2,14 @@ package server
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"time"
 
 	"github.com/caarlos0/env/v6"
+
+	"github.com/OmAsana/yapraktikum/internal/repository"
+	"github.com/OmAsana/yapraktikum/internal/repository/sql"
 )
 
 var (
@@ -16,6 +20,17 @@ var (
 	DefaultHashKey       = ""
 	DefaultDatabaseDSN   = ""
 	DefaultLogLevel      = "info"
+	DefaultGRPCAddress   = ""
+	DefaultCryptoKey     = ""
+	DefaultTrustedSubnet = ""
+
+	DefaultHistoryRetention = 0 * time.Second
+	DefaultHistoryCapacity  = 1024
+
+	DefaultDBQueryTimeout = sql.DefaultQueryTimeout
+	DefaultDBBulkTimeout  = sql.DefaultBulkTimeout
+
+	DefaultIdempotencyWindow = repository.DefaultIdempotencyWindow
 
 	DefaultConfig = Config{
 		Address:       DefaultAddress,
@@ -24,6 +39,17 @@ var (
 		Restore:       DefaultRestore,
 		DatabaseDSN:   DefaultDatabaseDSN,
 		LogLevel:      DefaultLogLevel,
+		GRPCAddress:   DefaultGRPCAddress,
+		CryptoKey:     DefaultCryptoKey,
+		TrustedSubnet: DefaultTrustedSubnet,
+
+		HistoryRetention: DefaultHistoryRetention,
+		HistoryCapacity:  DefaultHistoryCapacity,
+
+		DBQueryTimeout: DefaultDBQueryTimeout,
+		DBBulkTimeout:  DefaultDBBulkTimeout,
+
+		IdempotencyWindow: DefaultIdempotencyWindow,
 	}
 )
 
@@ -35,6 +61,17 @@ type Config struct {
 	HashKey       string        `env:"KEY"`
 	DatabaseDSN   string        `env:"DATABASE_DSN"`
 	LogLevel      string        `env:"LOG_LEVEL"`
+	GRPCAddress   string        `env:"GRPC_ADDRESS"`
+	CryptoKey     string        `env:"CRYPTO_KEY"`
+	TrustedSubnet string        `env:"TRUSTED_SUBNET"`
+
+	HistoryRetention time.Duration `env:"HISTORY_RETENTION"`
+	HistoryCapacity  int           `env:"HISTORY_CAPACITY"`
+
+	DBQueryTimeout time.Duration `env:"DATABASE_QUERY_TIMEOUT"`
+	DBBulkTimeout  time.Duration `env:"DATABASE_BULK_TIMEOUT"`
+
+	IdempotencyWindow time.Duration `env:"IDEMPOTENCY_WINDOW"`
 }
 
 func InitConfig() (*Config, error) {
@@ -48,9 +85,20 @@ func InitConfig() (*Config, error) {
 		return nil, err
 	}
 
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
 
+func (c *Config) validate() error {
+	// gRPC updates are not encrypted, a private key would not protect them.
+	if c.CryptoKey != "" && c.GRPCAddress != "" {
+		return fmt.Errorf("crypto key can not be used with gRPC")
+	}
+	return nil
+}
+
 func (c *Config) initCmdFlagsWithArgs(args []string) error {
 	command := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 
@@ -61,6 +109,14 @@ func (c *Config) initCmdFlagsWithArgs(args []string) error {
 	hashKey := command.String("k", DefaultHashKey, "Hash key")
 	databaseDSN := command.String("d", DefaultDatabaseDSN, "Postgre database connection string")
 	logLevel := command.String("log_level", DefaultLogLevel, "Log level")
+	grpcAddress := command.String("g", DefaultGRPCAddress, "Listen for gRPC on address. Empty disables gRPC. Can not be used with crypto-key")
+	cryptoKey := command.String("crypto-key", DefaultCryptoKey, "Path to PEM private key. Batch updates must be encrypted with its public key")
+	trustedSubnet := command.String("t", DefaultTrustedSubnet, "Accept updates only from agents in this CIDR. Empty accepts everyone")
+	historyRetention := command.Duration("history_retention", DefaultHistoryRetention, "Keep metric history for this long. 0 disables history")
+	historyCapacity := command.Int("history_capacity", DefaultHistoryCapacity, "Max history samples per series in memory mode")
+	dbQueryTimeout := command.Duration("db_query_timeout", DefaultDBQueryTimeout, "Max time a single database query may run. 0 waits until the request is done")
+	dbBulkTimeout := command.Duration("db_bulk_timeout", DefaultDBBulkTimeout, "Max time a batch write to the database may run. 0 waits until the request is done")
+	idempotencyWindow := command.Duration("idempotency_window", DefaultIdempotencyWindow, "Replay responses to updates retried with the same Idempotency-Key within this window. 0 ignores keys")
 
 	if err := command.Parse(args); err != nil {
 		return err
@@ -73,6 +129,14 @@ func (c *Config) initCmdFlagsWithArgs(args []string) error {
 	c.HashKey = *hashKey
 	c.DatabaseDSN = *databaseDSN
 	c.LogLevel = *logLevel
+	c.GRPCAddress = *grpcAddress
+	c.CryptoKey = *cryptoKey
+	c.TrustedSubnet = *trustedSubnet
+	c.HistoryRetention = *historyRetention
+	c.HistoryCapacity = *historyCapacity
+	c.DBQueryTimeout = *dbQueryTimeout
+	c.DBBulkTimeout = *dbBulkTimeout
+	c.IdempotencyWindow = *idempotencyWindow
 
 	return nil
 }
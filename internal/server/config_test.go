@@ -52,6 +52,15 @@ func TestServerInitConfig(t *testing.T) {
 	})
 }
 
+func TestConfig_validate(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.CryptoKey = "private.pem"
+	assert.NoError(t, cfg.validate())
+
+	cfg.GRPCAddress = "127.0.0.1:3200"
+	assert.Error(t, cfg.validate())
+}
+
 func Test_initCmdFlags(t *testing.T) {
 	t.Run("test default args", func(t *testing.T) {
 		cfg := DefaultConfig
@@ -78,6 +87,12 @@ func Test_initCmdFlags(t *testing.T) {
 			StoreFile:     "/tmp/random_file",
 			Restore:       false,
 			LogLevel:      DefaultLogLevel,
+
+			HistoryCapacity: DefaultHistoryCapacity,
+			DBQueryTimeout:  DefaultDBQueryTimeout,
+			DBBulkTimeout:   DefaultDBBulkTimeout,
+
+			IdempotencyWindow: DefaultIdempotencyWindow,
 		}
 		assert.EqualValues(t, targetCfg, cfg)
 
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+
+	"github.com/OmAsana/yapraktikum/internal/handlers"
+	"github.com/OmAsana/yapraktikum/internal/labels"
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+	"github.com/OmAsana/yapraktikum/internal/proto"
+	"github.com/OmAsana/yapraktikum/internal/repository"
+)
+
+var _ proto.MetricsServer = (*GRPCServer)(nil)
+
+// GRPCServer exposes the repository of a MetricsServer over gRPC. It shares
+// the hash key, validation and logger with the HTTP handlers.
+type GRPCServer struct {
+	proto.UnimplementedMetricsServer
+	ms *MetricsServer
+}
+
+func NewGRPCServer(ms *MetricsServer) *GRPCServer {
+	return &GRPCServer{ms: ms}
+}
+
+// grpcUpdateMethods are guarded by the trusted subnet, reads stay open as
+// they do over HTTP.
+var grpcUpdateMethods = map[string]bool{
+	"/" + proto.Metrics_ServiceDesc.ServiceName + "/Update":  true,
+	"/" + proto.Metrics_ServiceDesc.ServiceName + "/Updates": true,
+}
+
+// ServerOptions returns interceptors the gRPC server must be created with.
+func (s *GRPCServer) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(s.unaryTrustedSubnet),
+		grpc.StreamInterceptor(s.streamTrustedSubnet),
+	}
+}
+
+func (s *GRPCServer) unaryTrustedSubnet(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if grpcUpdateMethods[info.FullMethod] {
+		if err := s.checkTrustedSubnet(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, req)
+}
+
+func (s *GRPCServer) streamTrustedSubnet(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if grpcUpdateMethods[info.FullMethod] {
+		if err := s.checkTrustedSubnet(stream.Context()); err != nil {
+			return err
+		}
+	}
+	return handler(srv, stream)
+}
+
+// checkTrustedSubnet is trustedSubnetHandler for gRPC.
+func (s *GRPCServer) checkTrustedSubnet(ctx context.Context) error {
+	if s.ms.trustedSubnet == nil {
+		return nil
+	}
+
+	ip, err := grpcRealIP(ctx)
+	if err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if !s.ms.trustedSubnet.Contains(ip) {
+		return status.Errorf(codes.PermissionDenied, "%s is not in trusted subnet", ip)
+	}
+	return nil
+}
+
+// grpcRealIP returns the address the agent reported in X-Real-IP metadata,
+// or the peer address if there is none.
+func grpcRealIP(ctx context.Context) (net.IP, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("X-Real-IP"); len(values) > 0 {
+			ip := net.ParseIP(values[0])
+			if ip == nil {
+				return nil, fmt.Errorf("invalid X-Real-IP %q", values[0])
+			}
+			return ip, nil
+		}
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("unknown peer address")
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer address %q", p.Addr)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid peer address %q", p.Addr)
+	}
+	return ip, nil
+}
+
+func (s *GRPCServer) Update(ctx context.Context, req *proto.UpdateRequest) (*proto.UpdateResponse, error) {
+	m, err := metricFromProto(req.GetMetric())
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := s.ms.hashIsValid(m)
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	setHeader := func(md metadata.MD) error {
+		return grpc.SetHeader(ctx, md)
+	}
+	if err := s.store(ctx, []handlers.Metrics{m}, setHeader); err != nil {
+		return nil, err
+	}
+	return &proto.UpdateResponse{}, nil
+}
+
+// Updates applies the streamed metrics atomically, so they are held until the
+// stream ends. The stream may not grow past the limit of HTTP request bodies.
+func (s *GRPCServer) Updates(stream proto.Metrics_UpdatesServer) error {
+	var metricList []handlers.Metrics
+	var size int64
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		size += int64(protobuf.Size(req))
+		if size > s.ms.maxDecompressedSize {
+			return status.Errorf(codes.ResourceExhausted, "streamed batch exceeds %d bytes", s.ms.maxDecompressedSize)
+		}
+
+		m, err := metricFromProto(req.GetMetric())
+		if err != nil {
+			return err
+		}
+		metricList = append(metricList, m)
+	}
+
+	if err := s.store(stream.Context(), metricList, stream.SetHeader); err != nil {
+		return err
+	}
+	return stream.SendAndClose(&proto.UpdatesResponse{Received: int64(len(metricList))})
+}
+
+// store applies metricList atomically. A request with an Idempotency-Key in
+// metadata is applied once, retries get the Idempotent-Replayed header.
+func (s *GRPCServer) store(ctx context.Context, metricList []handlers.Metrics, setHeader func(metadata.MD) error) error {
+	var key string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(handlers.HeaderIdempotencyKey); len(values) > 0 {
+			key = values[0]
+		}
+	}
+	if key == "" {
+		if err := s.ms.storeMetrics(ctx, metricList); err != nil {
+			return grpcError(err)
+		}
+		return nil
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return status.Error(codes.InvalidArgument, "idempotency key is too long")
+	}
+
+	_, replayed, err := s.ms.storeMetricsOnce(ctx, key, metricList)
+	if err != nil {
+		return grpcError(err)
+	}
+	if replayed {
+		s.ms.log.S().Debugf("Replaying response to request %s", key)
+		return setHeader(metadata.Pairs(headerIdempotentReplayed, "true"))
+	}
+	return nil
+}
+
+func (s *GRPCServer) Value(ctx context.Context, req *proto.ValueRequest) (*proto.ValueResponse, error) {
+	lbls := labels.FromMap(req.GetLabels())
+	if err := lbls.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	m, err := s.ms.lookupMetric(ctx, handlers.Metrics{
+		ID:     req.GetId(),
+		MType:  req.GetType(),
+		Labels: lbls,
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &proto.ValueResponse{Metric: proto.FromHandler(m)}, nil
+}
+
+func (s *GRPCServer) List(ctx context.Context, req *proto.ListRequest) (*proto.ListResponse, error) {
+	matchers, err := labels.ParseMatchers(req.GetMatchers())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	gauges, counters, err := s.ms.db.ListStoredMetrics(ctx, matchers...)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	resp := &proto.ListResponse{}
+	for _, g := range gauges {
+		m := metrics.GaugeToHandlerScheme(g)
+		if err := s.ms.writeHash(&m); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		resp.Metrics = append(resp.Metrics, proto.FromHandler(m))
+	}
+	for _, c := range counters {
+		m := metrics.CounterToHandlerScheme(c)
+		if err := s.ms.writeHash(&m); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		resp.Metrics = append(resp.Metrics, proto.FromHandler(m))
+	}
+
+	histograms, err := s.ms.db.ListStoredHistograms(ctx, matchers...)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	for _, h := range histograms {
+		m := metrics.HistogramToHandlerScheme(h)
+		if err := s.ms.writeHash(&m); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		resp.Metrics = append(resp.Metrics, proto.FromHandler(m))
+	}
+	return resp, nil
+}
+
+// metricFromProto applies the same required field checks as the JSON decoder.
+func metricFromProto(pm *proto.Metric) (handlers.Metrics, error) {
+	if pm == nil {
+		return handlers.Metrics{}, status.Error(codes.InvalidArgument, "metric is required")
+	}
+
+	m := pm.ToHandler()
+	if m.ID == "" || m.MType == "" {
+		return m, status.Error(codes.InvalidArgument, "missing required fields")
+	}
+	if err := m.Labels.Validate(); err != nil {
+		return m, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return m, nil
+}
+
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, errInvalidMetric):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, errMetricNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, repository.ErrorIdempotencyKeyReused):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		return status.Error(codes.Internal, "internal error")
+	}
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	protobuf "google.golang.org/protobuf/proto"
+
+	"github.com/OmAsana/yapraktikum/internal/handlers"
+	"github.com/OmAsana/yapraktikum/internal/pkg"
+	"github.com/OmAsana/yapraktikum/internal/proto"
+)
+
+func setupGRPC(t *testing.T, opts ...Options) proto.MetricsClient {
+	t.Helper()
+	ms, err := NewMetricsServer(SetupRepo(t), opts...)
+	require.NoError(t, err)
+
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := NewGRPCServer(ms)
+	srv := grpc.NewServer(grpcServer.ServerOptions()...)
+	proto.RegisterMetricsServer(srv, grpcServer)
+	go func() {
+		_ = srv.Serve(listener)
+	}()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return proto.NewMetricsClient(conn)
+}
+
+func TestGRPCServer(t *testing.T) {
+	ctx := context.Background()
+	key := "secret"
+	client := setupGRPC(t, WithHashKey(key))
+
+	signed := func(m handlers.Metrics) *proto.Metric {
+		require.NoError(t, m.HashMetric(key))
+		return proto.FromHandler(m)
+	}
+
+	t.Run("update and value", func(t *testing.T) {
+		_, err := client.Update(ctx, &proto.UpdateRequest{Metric: signed(handlers.Metrics{
+			ID: "Alloc", MType: "gauge", Value: pkg.PointerFloat(1.5),
+		})})
+		require.NoError(t, err)
+
+		resp, err := client.Value(ctx, &proto.ValueRequest{Id: "Alloc", Type: "gauge"})
+		require.NoError(t, err)
+		assert.Equal(t, 1.5, resp.GetMetric().GetValue())
+		assert.NotEmpty(t, resp.GetMetric().GetHash())
+	})
+
+	t.Run("streamed updates", func(t *testing.T) {
+		stream, err := client.Updates(ctx)
+		require.NoError(t, err)
+		for _, m := range []handlers.Metrics{
+			{ID: "PollCount", MType: "counter", Delta: pkg.PointerInt(2)},
+			{ID: "PollCount", MType: "counter", Delta: pkg.PointerInt(3)},
+			{ID: "Alloc", MType: "gauge", Value: pkg.PointerFloat(7), Labels: map[string]string{"host": "a"}},
+		} {
+			require.NoError(t, stream.Send(&proto.UpdateRequest{Metric: signed(m)}))
+		}
+		resp, err := stream.CloseAndRecv()
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), resp.GetReceived())
+
+		value, err := client.Value(ctx, &proto.ValueRequest{Id: "PollCount", Type: "counter"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), value.GetMetric().GetDelta())
+
+		list, err := client.List(ctx, &proto.ListRequest{Matchers: []string{"host=a"}})
+		require.NoError(t, err)
+		require.Len(t, list.GetMetrics(), 1)
+		assert.Equal(t, float64(7), list.GetMetrics()[0].GetValue())
+	})
+
+	t.Run("invalid hash", func(t *testing.T) {
+		m := signed(handlers.Metrics{ID: "Alloc", MType: "gauge", Value: pkg.PointerFloat(1)})
+		m.Value = pkg.PointerFloat(2)
+		_, err := client.Update(ctx, &proto.UpdateRequest{Metric: m})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("invalid metric", func(t *testing.T) {
+		_, err := client.Update(ctx, &proto.UpdateRequest{Metric: &proto.Metric{Id: "Alloc", Type: "gauge"}})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		_, err = client.Update(ctx, &proto.UpdateRequest{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := client.Value(ctx, &proto.ValueRequest{Id: "Unknown", Type: "gauge"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+}
+
+func TestGRPCServer_TrustedSubnet(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("192.168.1.0/24")
+	require.NoError(t, err)
+	client := setupGRPC(t, WithTrustedSubnet(subnet))
+
+	update := &proto.UpdateRequest{Metric: proto.FromHandler(handlers.Metrics{ID: "Alloc", MType: "gauge", Value: pkg.PointerFloat(1)})}
+	withIP := func(ip string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "X-Real-IP", ip)
+	}
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		wantCode codes.Code
+	}{
+		{name: "trusted", ctx: withIP("192.168.1.10"), wantCode: codes.OK},
+		{name: "untrusted", ctx: withIP("10.0.0.1"), wantCode: codes.PermissionDenied},
+		{name: "invalid", ctx: withIP("web-1"), wantCode: codes.PermissionDenied},
+		// The in-memory connection has no IP address.
+		{name: "missing", ctx: context.Background(), wantCode: codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.Update(tt.ctx, update)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+
+			stream, err := client.Updates(tt.ctx)
+			require.NoError(t, err)
+			require.NoError(t, stream.Send(update))
+			_, err = stream.CloseAndRecv()
+			assert.Equal(t, tt.wantCode, status.Code(err))
+		})
+	}
+
+	t.Run("read stays open", func(t *testing.T) {
+		_, err := client.Value(withIP("10.0.0.1"), &proto.ValueRequest{Id: "Alloc", Type: "gauge"})
+		assert.NoError(t, err)
+	})
+}
+
+func TestGRPCServer_IdempotencyKey(t *testing.T) {
+	client := setupGRPC(t)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), handlers.HeaderIdempotencyKey, "batch-1")
+	update := &proto.UpdateRequest{Metric: proto.FromHandler(handlers.Metrics{ID: "Requests", MType: "counter", Delta: pkg.PointerInt(5)})}
+
+	send := func(ctx context.Context) metadata.MD {
+		stream, err := client.Updates(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(update))
+		_, err = stream.CloseAndRecv()
+		require.NoError(t, err)
+		header, err := stream.Header()
+		require.NoError(t, err)
+		return header
+	}
+
+	assert.Empty(t, send(ctx).Get(headerIdempotentReplayed))
+	assert.Equal(t, []string{"true"}, send(ctx).Get(headerIdempotentReplayed))
+
+	var header metadata.MD
+	_, err := client.Update(ctx, update, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"true"}, header.Get(headerIdempotentReplayed))
+
+	resp, err := client.Value(context.Background(), &proto.ValueRequest{Id: "Requests", Type: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *resp.GetMetric().ToHandler().Delta)
+
+	other := &proto.UpdateRequest{Metric: proto.FromHandler(handlers.Metrics{ID: "Requests", MType: "counter", Delta: pkg.PointerInt(7)})}
+	_, err = client.Update(ctx, other)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	tooLong := metadata.AppendToOutgoingContext(context.Background(), handlers.HeaderIdempotencyKey, strings.Repeat("k", maxIdempotencyKeyLength+1))
+	_, err = client.Update(tooLong, update)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCServer_StreamLimit(t *testing.T) {
+	update := &proto.UpdateRequest{Metric: proto.FromHandler(handlers.Metrics{ID: "Requests", MType: "counter", Delta: pkg.PointerInt(1)})}
+	client := setupGRPC(t, WithMaxDecompressedSize(int64(10*protobuf.Size(update))))
+
+	send := func(n int) error {
+		stream, err := client.Updates(context.Background())
+		require.NoError(t, err)
+		for i := 0; i < n; i++ {
+			if err := stream.Send(update); err != nil {
+				break
+			}
+		}
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+
+	require.NoError(t, send(10))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(send(11)))
+
+	resp, err := client.Value(context.Background(), &proto.ValueRequest{Id: "Requests", Type: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), *resp.GetMetric().ToHandler().Delta)
+}
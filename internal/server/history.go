@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/OmAsana/yapraktikum/internal/labels"
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+	"github.com/OmAsana/yapraktikum/internal/repository"
+)
+
+const defaultHistoryRange = time.Hour
+
+type historyResponse struct {
+	ID     string           `json:"id"`
+	MType  string           `json:"type"`
+	Labels labels.Labels    `json:"labels,omitempty"`
+	Points []metrics.Sample `json:"points"`
+}
+
+// History returns recorded samples of a single series, e.g.
+// /history/gauge/HeapAlloc?from=2022-01-01T10:00:00Z&to=2022-01-01T11:00:00Z&step=1m&host=web-1
+// from and to accept RFC3339 or unix seconds and default to the last hour.
+// Labels are passed the same way as for /value/.
+func (ms MetricsServer) History() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		metricType := chi.URLParam(request, "metricType")
+		metricName := chi.URLParam(request, "metricName")
+		if metricType != "gauge" && metricType != "counter" {
+			http.Error(writer, "wrong metric type", http.StatusNotFound)
+			return
+		}
+
+		query := request.URL.Query()
+		now := time.Now()
+		to, err := parseHistoryTime(query.Get("to"), now)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		from, err := parseHistoryTime(query.Get("from"), to.Add(-defaultHistoryRange))
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if from.After(to) {
+			http.Error(writer, "from must not be after to", http.StatusBadRequest)
+			return
+		}
+
+		var step time.Duration
+		if s := query.Get("step"); s != "" {
+			step, err = time.ParseDuration(s)
+			if err != nil || step <= 0 {
+				http.Error(writer, "step must be a positive duration", http.StatusBadRequest)
+				return
+			}
+		}
+
+		lbls, err := labelsFromQuery(request, "from", "to", "step")
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		samples, err := ms.db.RetrieveHistory(request.Context(), metricType, metricName, lbls, from, to)
+		switch {
+		case errors.Is(err, repository.ErrorHistoryDisabled):
+			http.Error(writer, err.Error(), http.StatusNotImplemented)
+			return
+		case errors.Is(err, repository.ErrorHistoryNotFound):
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(writer, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		resp := historyResponse{
+			ID:     metricName,
+			MType:  metricType,
+			Labels: lbls,
+			Points: metrics.Downsample(samples, from, step),
+		}
+		if resp.Points == nil {
+			resp.Points = []metrics.Sample{}
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(writer).Encode(resp); err != nil {
+			ms.log.S().Error(err)
+		}
+	}
+}
+
+func parseHistoryTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	return t, nil
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/OmAsana/yapraktikum/internal/labels"
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+	"github.com/OmAsana/yapraktikum/internal/repository/inmemorystore"
+)
+
+func TestMetricsServer_History(t *testing.T) {
+	t.Run("history disabled", func(t *testing.T) {
+		srv, err := NewMetricsServer(SetupRepo(t))
+		require.NoError(t, err)
+		ts := httptest.NewServer(srv)
+		defer ts.Close()
+
+		resp, body := testRequest(t, ts, http.MethodGet, "/history/gauge/Alloc", nil)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusNotImplemented, resp.StatusCode, body)
+	})
+
+	repo := SetupRepo(t, inmemorystore.WithHistory(time.Hour, 10))
+	for _, v := range []float64{1, 2, 3} {
+		require.NoError(t, repo.StoreGauge(context.Background(), metrics.Gauge{Name: "Alloc", Value: v}))
+	}
+	require.NoError(t, repo.StoreGauge(context.Background(), metrics.Gauge{Name: "Alloc", Value: 10, Labels: labels.Labels{"host": "a"}}))
+	for _, v := range []int64{1, 2} {
+		require.NoError(t, repo.StoreCounter(context.Background(), metrics.Counter{Name: "PollCount", Value: v}))
+	}
+
+	srv, err := NewMetricsServer(repo)
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	getHistory := func(t *testing.T, uri string) historyResponse {
+		t.Helper()
+		resp, body := testRequest(t, ts, http.MethodGet, uri, nil)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+
+		var h historyResponse
+		require.NoError(t, json.Unmarshal([]byte(body), &h))
+		return h
+	}
+	values := func(points []metrics.Sample) []float64 {
+		var v []float64
+		for _, p := range points {
+			v = append(v, p.Value)
+		}
+		return v
+	}
+
+	t.Run("raw gauge samples", func(t *testing.T) {
+		h := getHistory(t, "/history/gauge/Alloc")
+		assert.Equal(t, []float64{1, 2, 3}, values(h.Points))
+	})
+
+	t.Run("labeled series", func(t *testing.T) {
+		h := getHistory(t, "/history/gauge/Alloc?host=a")
+		assert.Equal(t, []float64{10}, values(h.Points))
+		assert.Equal(t, labels.Labels{"host": "a"}, h.Labels)
+	})
+
+	t.Run("counter is cumulative", func(t *testing.T) {
+		h := getHistory(t, "/history/counter/PollCount")
+		assert.Equal(t, []float64{1, 3}, values(h.Points))
+	})
+
+	t.Run("step keeps last sample per bucket", func(t *testing.T) {
+		h := getHistory(t, "/history/gauge/Alloc?step=1h")
+		assert.Equal(t, []float64{3}, values(h.Points))
+	})
+
+	t.Run("empty range", func(t *testing.T) {
+		h := getHistory(t, "/history/gauge/Alloc?from=0&to=1")
+		assert.Empty(t, h.Points)
+	})
+
+	t.Run("bad requests", func(t *testing.T) {
+		for uri, code := range map[string]int{
+			"/history/gauge/Unknown":                  http.StatusNotFound,
+			"/history/histogram/Alloc":                http.StatusNotFound,
+			"/history/gauge/Alloc?from=yesterday":     http.StatusBadRequest,
+			"/history/gauge/Alloc?step=-1s":           http.StatusBadRequest,
+			"/history/gauge/Alloc?from=100&to=10":     http.StatusBadRequest,
+			"/history/gauge/Alloc?step=1m&bad-name=1": http.StatusBadRequest,
+		} {
+			resp, body := testRequest(t, ts, http.MethodGet, uri, nil)
+			resp.Body.Close()
+			assert.Equal(t, code, resp.StatusCode, uri+": "+body)
+		}
+	})
+}
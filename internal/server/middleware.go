@@ -1,15 +1,137 @@
 package server
 
 import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 
 	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/OmAsana/yapraktikum/internal/encrypt"
 )
 
 var compressor = middleware.NewCompressor(
 	5,
 )
 
+// DefaultMaxDecompressedSize caps decoded request bodies against zip bombs.
+const DefaultMaxDecompressedSize int64 = 10 << 20
+
 func compressorHandler(next http.Handler) http.Handler {
 	return compressor.Handler(next)
 }
+
+// decompressHandler decodes gzip request bodies. Decoding stops once the body
+// grows past the configured limit.
+func (ms MetricsServer) decompressHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		encoding := request.Header.Get("Content-Encoding")
+		if encoding == "" || encoding == "identity" {
+			next.ServeHTTP(writer, request)
+			return
+		}
+		if encoding != "gzip" {
+			http.Error(writer, fmt.Sprintf("unsupported content encoding %q", encoding), http.StatusUnsupportedMediaType)
+			return
+		}
+
+		gz, err := gzip.NewReader(request.Body)
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("invalid gzip body: %s", err), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+
+		data, err := io.ReadAll(io.LimitReader(gz, ms.maxDecompressedSize+1))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("invalid gzip body: %s", err), http.StatusBadRequest)
+			return
+		}
+		if int64(len(data)) > ms.maxDecompressedSize {
+			http.Error(writer, fmt.Sprintf("decompressed body exceeds %d bytes", ms.maxDecompressedSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		request.Header.Del("Content-Encoding")
+		request.Body = io.NopCloser(bytes.NewReader(data))
+		request.ContentLength = int64(len(data))
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// decryptHandler opens hybrid encrypted request bodies. Once the server has a
+// private key, plain bodies are rejected so that metrics are never accepted
+// unencrypted by mistake. Content-Encoding of an encrypted request describes
+// the payload inside the envelope, so it must run before decompressHandler.
+func (ms MetricsServer) decryptHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		scheme := request.Header.Get(encrypt.HeaderEncryption)
+		switch {
+		case scheme == "" && ms.privateKey == nil:
+			next.ServeHTTP(writer, request)
+			return
+		case scheme == "":
+			http.Error(writer, fmt.Sprintf("encrypted payload expected: set %s: %s", encrypt.HeaderEncryption, encrypt.HybridScheme), http.StatusBadRequest)
+			return
+		case ms.privateKey == nil:
+			http.Error(writer, "encrypted payload is not supported: server has no private key", http.StatusBadRequest)
+			return
+		case scheme != encrypt.HybridScheme:
+			http.Error(writer, fmt.Sprintf("unsupported encryption scheme %q", scheme), http.StatusBadRequest)
+			return
+		}
+
+		// The envelope is read whole before decrypting, so it shares the
+		// decoded body limit.
+		payload, err := io.ReadAll(io.LimitReader(request.Body, ms.maxDecompressedSize+1))
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if int64(len(payload)) > ms.maxDecompressedSize {
+			http.Error(writer, fmt.Sprintf("encrypted body exceeds %d bytes", ms.maxDecompressedSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		data, err := encrypt.DecryptHybrid(ms.privateKey, payload)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		request.Header.Del(encrypt.HeaderEncryption)
+		request.Body = io.NopCloser(bytes.NewReader(data))
+		request.ContentLength = int64(len(data))
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// trustedSubnetHandler only lets through requests whose agent reported
+// X-Real-IP belongs to the trusted subnet. Without a subnet every request
+// passes.
+func (ms MetricsServer) trustedSubnetHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if ms.trustedSubnet == nil {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		header := request.Header.Get("X-Real-IP")
+		if header == "" {
+			http.Error(writer, "X-Real-IP header is required", http.StatusForbidden)
+			return
+		}
+		ip := net.ParseIP(header)
+		if ip == nil {
+			http.Error(writer, fmt.Sprintf("invalid X-Real-IP %q", header), http.StatusForbidden)
+			return
+		}
+		if !ms.trustedSubnet.Contains(ip) {
+			http.Error(writer, fmt.Sprintf("%s is not in trusted subnet", ip), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
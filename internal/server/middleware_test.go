@@ -0,0 +1,219 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/OmAsana/yapraktikum/internal/encrypt"
+)
+
+func TestMetricsServer_Decrypt(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	payload := []byte(`[{"id": "Alloc", "type": "gauge", "value": 1}]`)
+	envelope, err := encrypt.EncryptHybrid(&priv.PublicKey, payload)
+	require.NoError(t, err)
+
+	post := func(ts *httptest.Server, body []byte, scheme string) (*http.Response, string) {
+		return executeTestRequest(t, ts, func() (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", bytes.NewReader(body))
+			if err != nil {
+				return req, err
+			}
+			req.Header.Set("Content-Type", "application/json")
+			if scheme != "" {
+				req.Header.Set(encrypt.HeaderEncryption, scheme)
+			}
+			return req, err
+		})
+	}
+
+	t.Run("with private key", func(t *testing.T) {
+		repo := SetupRepo(t)
+		srv, err := NewMetricsServer(repo, WithPrivateKey(priv))
+		require.NoError(t, err)
+		ts := httptest.NewServer(srv)
+		defer ts.Close()
+
+		tests := []struct {
+			name     string
+			body     []byte
+			scheme   string
+			wantCode int
+			wantBody string
+		}{
+			{name: "encrypted", body: envelope, scheme: encrypt.HybridScheme, wantCode: http.StatusOK},
+			{name: "plain", body: payload, wantCode: http.StatusBadRequest, wantBody: "encrypted payload expected"},
+			{name: "unknown scheme", body: envelope, scheme: "rot13", wantCode: http.StatusBadRequest, wantBody: "unsupported encryption scheme"},
+			{name: "not an envelope", body: payload, scheme: encrypt.HybridScheme, wantCode: http.StatusBadRequest, wantBody: encrypt.ErrMalformedEnvelope.Error()},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				resp, body := post(ts, tt.body, tt.scheme)
+				defer resp.Body.Close()
+				require.Equal(t, tt.wantCode, resp.StatusCode, body)
+				assert.True(t, strings.Contains(body, tt.wantBody), body)
+			})
+		}
+
+		got, err := repo.RetrieveGauge(context.Background(), "Alloc", nil)
+		require.NoError(t, err)
+		assert.Equal(t, 1.0, got.Value)
+	})
+
+	t.Run("oversized envelope", func(t *testing.T) {
+		srv, err := NewMetricsServer(SetupRepo(t), WithPrivateKey(priv), WithMaxDecompressedSize(int64(len(envelope)-1)))
+		require.NoError(t, err)
+		ts := httptest.NewServer(srv)
+		defer ts.Close()
+
+		resp, body := post(ts, envelope, encrypt.HybridScheme)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode, body)
+		assert.Contains(t, body, "encrypted body exceeds")
+	})
+
+	t.Run("without private key", func(t *testing.T) {
+		srv, err := NewMetricsServer(SetupRepo(t))
+		require.NoError(t, err)
+		ts := httptest.NewServer(srv)
+		defer ts.Close()
+
+		resp, body := post(ts, envelope, encrypt.HybridScheme)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+		assert.Contains(t, body, "server has no private key")
+
+		resp, body = post(ts, payload, "")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	})
+}
+
+func TestMetricsServer_TrustedSubnet(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("192.168.1.0/24")
+	require.NoError(t, err)
+	srv, err := NewMetricsServer(SetupRepo(t), WithTrustedSubnet(subnet))
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		method   string
+		uri      string
+		body     string
+		realIP   string
+		wantCode int
+	}{
+		{name: "trusted", method: http.MethodPost, uri: "/update/gauge/Alloc/1", realIP: "192.168.1.10", wantCode: http.StatusOK},
+		{name: "untrusted", method: http.MethodPost, uri: "/update/gauge/Alloc/1", realIP: "10.0.0.1", wantCode: http.StatusForbidden},
+		{name: "missing header", method: http.MethodPost, uri: "/update/gauge/Alloc/1", wantCode: http.StatusForbidden},
+		{name: "invalid header", method: http.MethodPost, uri: "/update/gauge/Alloc/1", realIP: "web-1", wantCode: http.StatusForbidden},
+		{name: "batch untrusted", method: http.MethodPost, uri: "/updates/", body: `[{"id": "Alloc", "type": "gauge", "value": 1}]`, realIP: "10.0.0.1", wantCode: http.StatusForbidden},
+		{name: "batch trusted", method: http.MethodPost, uri: "/updates/", body: `[{"id": "Alloc", "type": "gauge", "value": 1}]`, realIP: "192.168.1.10", wantCode: http.StatusOK},
+		{name: "read stays open", method: http.MethodGet, uri: "/value/gauge/Alloc", realIP: "10.0.0.1", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := executeTestRequest(t, ts, func() (*http.Request, error) {
+				req, err := http.NewRequest(tt.method, ts.URL+tt.uri, strings.NewReader(tt.body))
+				if err != nil {
+					return req, err
+				}
+				req.Header.Set("Content-Type", "application/json")
+				if tt.realIP != "" {
+					req.Header.Set("X-Real-IP", tt.realIP)
+				}
+				return req, err
+			})
+			defer resp.Body.Close()
+			require.Equal(t, tt.wantCode, resp.StatusCode, body)
+		})
+	}
+}
+
+func gzipBody(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestMetricsServer_Decompress(t *testing.T) {
+	repo := SetupRepo(t)
+	srv, err := NewMetricsServer(repo, WithMaxDecompressedSize(1024))
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	payload := []byte(`[{"id": "Alloc", "type": "gauge", "value": 1}]`)
+	bomb := append([]byte(`[{"id": "Alloc", "type": "gauge", "value": 1}`), bytes.Repeat([]byte(" "), 2048)...)
+
+	tests := []struct {
+		name     string
+		body     []byte
+		encoding string
+		wantCode int
+	}{
+		{name: "gzip", body: gzipBody(t, payload), encoding: "gzip", wantCode: http.StatusOK},
+		{name: "plain", body: payload, wantCode: http.StatusOK},
+		{name: "not gzip", body: payload, encoding: "gzip", wantCode: http.StatusBadRequest},
+		{name: "too large", body: gzipBody(t, bomb), encoding: "gzip", wantCode: http.StatusRequestEntityTooLarge},
+		{name: "unsupported", body: payload, encoding: "br", wantCode: http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := executeTestRequest(t, ts, func() (*http.Request, error) {
+				req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", bytes.NewReader(tt.body))
+				if err != nil {
+					return req, err
+				}
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("Content-Encoding", tt.encoding)
+				return req, err
+			})
+			defer resp.Body.Close()
+			require.Equal(t, tt.wantCode, resp.StatusCode, body)
+		})
+	}
+
+	t.Run("encrypted gzip", func(t *testing.T) {
+		priv, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		srv, err := NewMetricsServer(SetupRepo(t), WithPrivateKey(priv))
+		require.NoError(t, err)
+		ts := httptest.NewServer(srv)
+		defer ts.Close()
+
+		envelope, err := encrypt.EncryptHybrid(&priv.PublicKey, gzipBody(t, payload))
+		require.NoError(t, err)
+		resp, body := executeTestRequest(t, ts, func() (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", bytes.NewReader(envelope))
+			if err != nil {
+				return req, err
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", "gzip")
+			req.Header.Set(encrypt.HeaderEncryption, encrypt.HybridScheme)
+			return req, err
+		})
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	})
+}
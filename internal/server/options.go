@@ -1,6 +1,11 @@
 package server
 
-import "github.com/OmAsana/yapraktikum/internal/logging"
+import (
+	"crypto/rsa"
+	"net"
+
+	"github.com/OmAsana/yapraktikum/internal/logging"
+)
 
 type Options func(server *MetricsServer)
 
@@ -15,3 +20,25 @@ func WithLogger(logger *logging.Logger) Options {
 		server.log = logger
 	}
 }
+
+// WithPrivateKey makes the server require hybrid encrypted batch updates.
+func WithPrivateKey(key *rsa.PrivateKey) Options {
+	return func(server *MetricsServer) {
+		server.privateKey = key
+	}
+}
+
+// WithTrustedSubnet rejects updates from agents outside subnet.
+func WithTrustedSubnet(subnet *net.IPNet) Options {
+	return func(server *MetricsServer) {
+		server.trustedSubnet = subnet
+	}
+}
+
+// WithMaxDecompressedSize limits the size of gzip request bodies once decoded,
+// of encrypted envelopes and of batches streamed over gRPC.
+func WithMaxDecompressedSize(size int64) Options {
+	return func(server *MetricsServer) {
+		server.maxDecompressedSize = size
+	}
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/OmAsana/yapraktikum/internal/labels"
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+)
+
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+func (ms MetricsServer) PrometheusMetrics() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		matchers, err := matchersFromQuery(request)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		gauges, counters, err := ms.db.ListStoredMetrics(request.Context(), matchers...)
+		if err != nil {
+			http.Error(writer, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		histograms, err := ms.db.ListStoredHistograms(request.Context(), matchers...)
+		if err != nil {
+			http.Error(writer, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		rendered, dropped := renderPrometheus(gauges, counters, histograms)
+		if len(dropped) > 0 {
+			ms.log.S().Warnf("Metrics clash with others once sanitized, not exported: %s", strings.Join(dropped, ", "))
+		}
+
+		writer.Header().Set("Content-Type", prometheusContentType)
+		_, err = io.WriteString(writer, rendered)
+		if err != nil {
+			ms.log.S().Error(err)
+		}
+	}
+}
+
+type promSample struct {
+	// series orders samples within a family. It excludes the le label, so
+	// buckets of one histogram stay together and in bound order.
+	series string
+	suffix string
+	labels string
+	value  string
+}
+
+type promFamily struct {
+	name    string
+	mType   string
+	samples []promSample
+	// series holds the series already in the family.
+	series map[string]bool
+}
+
+// renderPrometheus renders metrics in the text exposition format. Metrics
+// whose sanitized name and labels clash with an earlier one would make
+// Prometheus reject the whole scrape, they are left out and their names
+// returned as dropped.
+func renderPrometheus(gauges []metrics.Gauge, counters []metrics.Counter, histograms []metrics.Histogram) (string, []string) {
+	families := make(map[string]*promFamily)
+	var dropped []string
+	add := func(name string, lbls labels.Labels, mType string, samples ...promSample) {
+		sanitized := sanitizePrometheusName(name)
+		f, ok := families[sanitized]
+		if !ok {
+			f = &promFamily{name: sanitized, mType: mType, series: map[string]bool{}}
+			families[sanitized] = f
+		}
+		// Metrics of different types may collapse into the same name after
+		// sanitization. Prometheus rejects mixed families, so the first type
+		// wins, as does the first of identical series.
+		series := samples[0].series
+		if f.mType != mType || f.series[series] {
+			dropped = append(dropped, name+lbls.String())
+			return
+		}
+		f.series[series] = true
+		f.samples = append(f.samples, samples...)
+	}
+
+	for _, g := range gauges {
+		lbls := formatPrometheusLabels(prometheusLabels(g.Labels))
+		add(g.Name, g.Labels, "gauge", promSample{series: lbls, labels: lbls, value: formatPrometheusFloat(g.Value)})
+	}
+	for _, c := range counters {
+		lbls := formatPrometheusLabels(prometheusLabels(c.Labels))
+		add(c.Name, c.Labels, "counter", promSample{series: lbls, labels: lbls, value: strconv.FormatInt(c.Value, 10)})
+	}
+	for _, h := range histograms {
+		add(h.Name, h.Labels, "histogram", histogramSamples(h)...)
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		f := families[name]
+		sort.SliceStable(f.samples, func(i, j int) bool {
+			return f.samples[i].series < f.samples[j].series
+		})
+
+		sb.WriteString("# TYPE ")
+		sb.WriteString(f.name)
+		sb.WriteString(" ")
+		sb.WriteString(f.mType)
+		sb.WriteString("\n")
+		for _, s := range f.samples {
+			sb.WriteString(f.name)
+			sb.WriteString(s.suffix)
+			sb.WriteString(s.labels)
+			sb.WriteString(" ")
+			sb.WriteString(s.value)
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String(), dropped
+}
+
+// histogramSamples renders cumulative _bucket series with an le label, then
+// _sum and _count. A label of the histogram itself named le is exported as
+// exported_le, the way Prometheus renames clashing target labels.
+func histogramSamples(h metrics.Histogram) []promSample {
+	promLabels := prometheusLabels(h.Labels)
+	if le, ok := promLabels["le"]; ok {
+		delete(promLabels, "le")
+		if _, ok := promLabels["exported_le"]; !ok {
+			promLabels["exported_le"] = le
+		}
+	}
+	lbls := formatPrometheusLabels(promLabels)
+	cumulative := h.Cumulative()
+	samples := make([]promSample, 0, len(cumulative)+2)
+	for i, count := range cumulative {
+		le := "+Inf"
+		if i < len(h.Buckets) {
+			le = formatPrometheusFloat(h.Buckets[i])
+		}
+		samples = append(samples, promSample{
+			series: lbls,
+			suffix: "_bucket",
+			labels: formatPrometheusLabels(promLabels.Merge(labels.Labels{"le": le})),
+			value:  strconv.FormatUint(count, 10),
+		})
+	}
+	samples = append(samples,
+		promSample{series: lbls, suffix: "_sum", labels: lbls, value: formatPrometheusFloat(h.Sum)},
+		promSample{series: lbls, suffix: "_count", labels: lbls, value: strconv.FormatUint(h.Count, 10)},
+	)
+	return samples
+}
+
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// prometheusLabels sanitizes label names. Of names that clash once sanitized
+// the first one in sorted order wins.
+func prometheusLabels(lbls labels.Labels) labels.Labels {
+	if len(lbls) == 0 {
+		return nil
+	}
+	result := make(labels.Labels, len(lbls))
+	for _, k := range lbls.Names() {
+		name := sanitizePrometheusLabelName(k)
+		if _, ok := result[name]; !ok {
+			result[name] = lbls[k]
+		}
+	}
+	return result
+}
+
+// formatPrometheusLabels expects names sanitized by prometheusLabels.
+func formatPrometheusLabels(lbls labels.Labels) string {
+	if len(lbls) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, k := range lbls.Names() {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(k)
+		sb.WriteString(`="`)
+		sb.WriteString(prometheusLabelEscaper.Replace(lbls[k]))
+		sb.WriteString(`"`)
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+// sanitizePrometheusName maps name onto [a-zA-Z_:][a-zA-Z0-9_:]*
+func sanitizePrometheusName(name string) string {
+	if name == "" {
+		return "_"
+	}
+
+	var sb strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			sb.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				sb.WriteRune('_')
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('_')
+		}
+	}
+	return sb.String()
+}
+
+// sanitizePrometheusLabelName maps name onto [a-zA-Z_][a-zA-Z0-9_]*
+func sanitizePrometheusLabelName(name string) string {
+	return strings.ReplaceAll(sanitizePrometheusName(name), ":", "_")
+}
+
+func formatPrometheusFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/OmAsana/yapraktikum/internal/labels"
+	"github.com/OmAsana/yapraktikum/internal/metrics"
+)
+
+func TestMetricsServer_PrometheusMetrics(t *testing.T) {
+	repo := SetupRepo(t)
+	require.NoError(t, repo.StoreGauge(context.Background(), metrics.Gauge{Name: "Alloc", Value: 1.5}))
+	require.NoError(t, repo.StoreGauge(context.Background(), metrics.Gauge{Name: "1cpu.util", Value: 12}))
+	require.NoError(t, repo.StoreCounter(context.Background(), metrics.Counter{Name: "PollCount", Value: 7}))
+
+	srv, err := NewMetricsServer(repo)
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/metrics", nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	assert.Equal(t, prometheusContentType, resp.Header.Get("Content-Type"))
+
+	want := `# TYPE Alloc gauge
+Alloc 1.5
+# TYPE PollCount counter
+PollCount 7
+# TYPE _1cpu_util gauge
+_1cpu_util 12
+`
+	assert.Equal(t, want, body)
+}
+
+func Test_renderPrometheus(t *testing.T) {
+	latency := metrics.NewHistogram("latency", []float64{1})
+	latency.Labels = labels.Labels{"le": "user", "ns:id": "1"}
+	latency.Observe(0.5)
+
+	got, dropped := renderPrometheus(
+		[]metrics.Gauge{
+			{Name: "a.b", Value: 1, Labels: labels.Labels{"host": "x"}},
+			{Name: "a_b", Value: 2, Labels: labels.Labels{"host": "x"}},
+			{Name: "a_b", Value: 3, Labels: labels.Labels{"host": "y"}},
+			{Name: "up", Value: 1, Labels: labels.Labels{"a.b": "first", "a_b": "second"}},
+		},
+		[]metrics.Counter{{Name: "a:b", Value: 4}},
+		[]metrics.Histogram{latency},
+	)
+
+	want := `# TYPE a:b counter
+a:b 4
+# TYPE a_b gauge
+a_b{host="x"} 1
+a_b{host="y"} 3
+# TYPE latency histogram
+latency_bucket{exported_le="user",le="1",ns_id="1"} 1
+latency_bucket{exported_le="user",le="+Inf",ns_id="1"} 1
+latency_sum{exported_le="user",ns_id="1"} 0.5
+latency_count{exported_le="user",ns_id="1"} 1
+# TYPE up gauge
+up{a_b="first"} 1
+`
+	assert.Equal(t, want, got)
+	assert.Equal(t, []string{`a_b{host="x"}`}, dropped)
+}
+
+func Test_sanitizePrometheusName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "HeapAlloc", want: "HeapAlloc"},
+		{name: "CPUutilization1", want: "CPUutilization1"},
+		{name: "9lives", want: "_9lives"},
+		{name: "queue.depth-max", want: "queue_depth_max"},
+		{name: "ns:metric", want: "ns:metric"},
+		{name: "", want: "_"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%q", tt.name), func(t *testing.T) {
+			assert.Equal(t, tt.want, sanitizePrometheusName(tt.name))
+		})
+	}
+}
+
+func Test_sanitizePrometheusLabelName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "host", want: "host"},
+		{name: "9lives", want: "_9lives"},
+		{name: "ns:id", want: "ns_id"},
+		{name: "a.b", want: "a_b"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%q", tt.name), func(t *testing.T) {
+			assert.Equal(t, tt.want, sanitizePrometheusLabelName(tt.name))
+		})
+	}
+}
@@ -1,9 +1,15 @@
 package server
 
 import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
@@ -13,6 +19,7 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 
 	"github.com/OmAsana/yapraktikum/internal/handlers"
+	"github.com/OmAsana/yapraktikum/internal/labels"
 	"github.com/OmAsana/yapraktikum/internal/logging"
 	"github.com/OmAsana/yapraktikum/internal/metrics"
 	"github.com/OmAsana/yapraktikum/internal/pkg"
@@ -26,7 +33,11 @@ type MetricsServer struct {
 	storeFile     string
 	restore       bool
 	hashKey       string
+	privateKey    *rsa.PrivateKey
+	trustedSubnet *net.IPNet
 	log           *logging.Logger
+
+	maxDecompressedSize int64
 }
 
 func NewMetricsServer(db repository.MetricsRepository, opts ...Options) (*MetricsServer, error) {
@@ -37,6 +48,8 @@ func NewMetricsServer(db repository.MetricsRepository, opts ...Options) (*Metric
 		storeFile:     "",
 		restore:       false,
 		log:           logging.NewNoop(),
+
+		maxDecompressedSize: DefaultMaxDecompressedSize,
 	}
 
 	for _, opt := range opts {
@@ -57,12 +70,15 @@ func setupRoutes(srv *MetricsServer) {
 
 	srv.Get("/", srv.ReturnCurrentMetrics())
 	srv.Get("/ping", srv.Ping())
+	srv.Get("/metrics", srv.PrometheusMetrics())
 	srv.Get("/value/{metricType}/{metricName}", srv.GetMetric())
+	srv.Get("/history/{metricType}/{metricName}", srv.History())
 
-	srv.Post("/value/", srv.Value())
-	srv.Post("/updates/", srv.Updates())
+	srv.With(srv.decompressHandler).Post("/value/", srv.Value())
+	srv.With(srv.trustedSubnetHandler, srv.decryptHandler, srv.decompressHandler).Post("/updates/", srv.Updates())
 
 	srv.Route("/update", func(r chi.Router) {
+		r.Use(srv.trustedSubnetHandler, srv.decompressHandler)
 		r.Post("/", srv.Update())
 		r.Route("/counter/", func(r chi.Router) {
 			r.Post("/{counterName}/{counterValue}", srv.UpdateCounters())
@@ -78,6 +94,18 @@ func setupRoutes(srv *MetricsServer) {
 	})
 }
 
+const (
+	maxIdempotencyKeyLength = 255
+	// headerIdempotentReplayed marks a response replayed for a retried
+	// request instead of applying it again.
+	headerIdempotentReplayed = "Idempotent-Replayed"
+)
+
+var (
+	errInvalidMetric  = errors.New("invalid metric")
+	errMetricNotFound = errors.New("metric not found")
+)
+
 func (ms MetricsServer) Value() http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		if !pkg.Contains(request.Header.Values("accept"), "application/json") {
@@ -91,34 +119,12 @@ func (ms MetricsServer) Value() http.HandlerFunc {
 			return
 		}
 
-		switch m.MType {
-		case "counter":
-			c, err := ms.db.RetrieveCounter(m.ID)
-			if err != nil {
-				ms.log.S().Infof("Not found metric %+v", m)
-				http.Error(writer, err.Error(), http.StatusNotFound)
-				return
-			}
-
-			m.Delta = &c.Value
-
-		case "gauge":
-			g, err := ms.db.RetrieveGauge(m.ID)
-			if err != nil {
-				ms.log.S().Infof("Not found metric %+v", m)
-				http.Error(writer, err.Error(), http.StatusNotFound)
-				return
-			}
-
-			m.Value = &g.Value
-
-		default:
-			http.NotFound(writer, request)
+		m, err = ms.lookupMetric(request.Context(), m)
+		switch {
+		case errors.Is(err, errMetricNotFound):
+			http.Error(writer, err.Error(), http.StatusNotFound)
 			return
-
-		}
-
-		if err := ms.writeHash(&m); err != nil {
+		case err != nil:
 			http.Error(writer, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -136,6 +142,46 @@ func (ms MetricsServer) Value() http.HandlerFunc {
 	}
 }
 
+// lookupMetric fills the stored value of m and signs it.
+func (ms MetricsServer) lookupMetric(ctx context.Context, m handlers.Metrics) (handlers.Metrics, error) {
+	switch m.MType {
+	case "counter":
+		c, err := ms.db.RetrieveCounter(ctx, m.ID, m.Labels)
+		if err != nil {
+			ms.log.S().Infof("Not found metric %+v", m)
+			return m, fmt.Errorf("%w: %s", errMetricNotFound, err)
+		}
+
+		m.Delta = &c.Value
+
+	case "gauge":
+		g, err := ms.db.RetrieveGauge(ctx, m.ID, m.Labels)
+		if err != nil {
+			ms.log.S().Infof("Not found metric %+v", m)
+			return m, fmt.Errorf("%w: %s", errMetricNotFound, err)
+		}
+
+		m.Value = &g.Value
+
+	case "histogram":
+		h, err := ms.db.RetrieveHistogram(ctx, m.ID, m.Labels)
+		if err != nil {
+			ms.log.S().Infof("Not found metric %+v", m)
+			return m, fmt.Errorf("%w: %s", errMetricNotFound, err)
+		}
+
+		m = metrics.HistogramToHandlerScheme(h)
+
+	default:
+		return m, fmt.Errorf("%w: unknown type %q", errMetricNotFound, m.MType)
+	}
+
+	if err := ms.writeHash(&m); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
 func (ms MetricsServer) Update() http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		if !pkg.Contains(request.Header.Values("Content-Type"), "application/json") {
@@ -153,62 +199,220 @@ func (ms MetricsServer) Update() http.HandlerFunc {
 			http.Error(writer, err.Error(), http.StatusBadRequest)
 			return
 		}
-		ms.saveMetric(writer, m)
+		if key := request.Header.Get(handlers.HeaderIdempotencyKey); key != "" {
+			ms.saveMetricsOnce(request.Context(), writer, key, []handlers.Metrics{m})
+			return
+		}
+		ms.saveMetric(request.Context(), writer, m)
 	}
 }
 
-func (ms MetricsServer) saveMetric(writer http.ResponseWriter, m handlers.Metrics) {
+func (ms MetricsServer) saveMetric(ctx context.Context, writer http.ResponseWriter, m handlers.Metrics) {
+	err := ms.storeMetric(ctx, m)
+	switch {
+	case errors.Is(err, errInvalidMetric):
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(writer, "internal error", http.StatusInternalServerError)
+	default:
+		writer.WriteHeader(http.StatusOK)
+	}
+}
 
+func validateMetric(m handlers.Metrics) error {
 	switch m.MType {
 	case "counter":
 		if m.Delta == nil {
-			http.Error(writer, "delta can not be nil", http.StatusBadRequest)
-			return
+			return fmt.Errorf("%w: delta can not be nil", errInvalidMetric)
 		}
-
-		err := ms.db.StoreCounter(metrics.CounterFromHandler(m))
-		if err != nil {
-			http.Error(writer, "internal error", http.StatusInternalServerError)
-			return
+		if err := metrics.CounterFromHandler(m).IsValid(); err != nil {
+			return fmt.Errorf("%w: %s", errInvalidMetric, err)
 		}
-		writer.WriteHeader(http.StatusOK)
-		return
 	case "gauge":
 		if m.Value == nil {
-			http.Error(writer, "value can not be nil", http.StatusBadRequest)
-			return
+			return fmt.Errorf("%w: value can not be nil", errInvalidMetric)
+		}
+	case "histogram":
+		if m.Sum == nil || m.Count == nil {
+			return fmt.Errorf("%w: sum and count can not be nil", errInvalidMetric)
+		}
+		if err := metrics.HistogramFromHandler(m).IsValid(); err != nil {
+			return fmt.Errorf("%w: %s", errInvalidMetric, err)
 		}
+	default:
+		return fmt.Errorf("%w: wrong metric type", errInvalidMetric)
+	}
+	return nil
+}
 
-		err := ms.db.StoreGauge(metrics.GaugeFromHandler(m))
-		if err != nil {
-			http.Error(writer, "internal error", http.StatusInternalServerError)
-			return
+func (ms MetricsServer) storeMetric(ctx context.Context, m handlers.Metrics) error {
+	if err := validateMetric(m); err != nil {
+		return err
+	}
+
+	var err error
+	switch m.MType {
+	case "counter":
+		err = ms.db.StoreCounter(ctx, metrics.CounterFromHandler(m))
+	case "gauge":
+		err = ms.db.StoreGauge(ctx, metrics.GaugeFromHandler(m))
+	case "histogram":
+		err = ms.db.StoreHistogram(ctx, metrics.HistogramFromHandler(m))
+	}
+	if isInvalidMetric(err) {
+		return fmt.Errorf("%w: %s", errInvalidMetric, err)
+	}
+	if err != nil {
+		ms.log.S().Error("Write to db failed: ", err)
+	}
+	return err
+}
+
+// storeMetrics checks hashes and values of the whole batch before writing
+// it at once, so a failed batch leaves nothing stored.
+func (ms MetricsServer) storeMetrics(ctx context.Context, metricList []handlers.Metrics) error {
+	gauges, counters, histograms, err := ms.splitBatch(metricList)
+	if err != nil {
+		return err
+	}
+	return ms.batchError(ms.db.ApplyBatch(ctx, gauges, counters, histograms))
+}
+
+// storeMetricsOnce is storeMetrics for requests with an idempotency key. If
+// a request with the same key was applied already, it returns the response
+// saved for it and replayed set. A key reused with other metrics is refused
+// with repository.ErrorIdempotencyKeyReused.
+func (ms MetricsServer) storeMetricsOnce(ctx context.Context, key string, metricList []handlers.Metrics) (repository.IdempotentResponse, bool, error) {
+	response := repository.IdempotentResponse{StatusCode: http.StatusOK}
+	gauges, counters, histograms, err := ms.splitBatch(metricList)
+	if err != nil {
+		return response, false, err
+	}
+	hash, err := requestHash(metricList)
+	if err != nil {
+		return response, false, err
+	}
+	saved, replayed, err := ms.db.ApplyBatchOnce(ctx, key, hash, response, gauges, counters, histograms)
+	return saved, replayed, ms.batchError(err)
+}
+
+// requestHash identifies the metrics of a request regardless of how the body
+// was encoded, so a retry sent uncompressed still matches.
+func requestHash(metricList []handlers.Metrics) (string, error) {
+	data, err := json.Marshal(metricList)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (ms MetricsServer) splitBatch(metricList []handlers.Metrics) ([]metrics.Gauge, []metrics.Counter, []metrics.Histogram, error) {
+	for _, metric := range metricList {
+		ok, err := ms.hashIsValid(metric)
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("%w: %s", errInvalidMetric, err)
 		}
-		writer.WriteHeader(http.StatusOK)
+		if err := validateMetric(metric); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	var gauges []metrics.Gauge
+	var counters []metrics.Counter
+	var histograms []metrics.Histogram
+
+	for _, m := range metricList {
+		switch m.MType {
+		case "counter":
+			counters = append(counters, metrics.CounterFromHandler(m))
+		case "gauge":
+			gauges = append(gauges, metrics.GaugeFromHandler(m))
+		case "histogram":
+			histograms = append(histograms, metrics.HistogramFromHandler(m))
+		}
+	}
+	return gauges, counters, histograms, nil
+}
+
+func (ms MetricsServer) batchError(err error) error {
+	if isInvalidMetric(err) {
+		return fmt.Errorf("%w: %s", errInvalidMetric, err)
+	}
+	if errors.Is(err, repository.ErrorIdempotencyKeyReused) {
+		return err
+	}
+	if err != nil {
+		ms.log.S().Error("Bulk write to db failed: ", err)
+		return err
+	}
+	return nil
+}
+
+// saveMetricsOnce writes the response to the first request with key, a
+// replayed one is marked with a header.
+func (ms MetricsServer) saveMetricsOnce(ctx context.Context, writer http.ResponseWriter, key string, metricList []handlers.Metrics) {
+	if len(key) > maxIdempotencyKeyLength {
+		http.Error(writer, "idempotency key is too long", http.StatusBadRequest)
+		return
+	}
+
+	saved, replayed, err := ms.storeMetricsOnce(ctx, key, metricList)
+	switch {
+	case errors.Is(err, errInvalidMetric):
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, repository.ErrorIdempotencyKeyReused):
+		http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		http.Error(writer, "internal error", http.StatusInternalServerError)
 		return
-	default:
-		http.Error(writer, "wrong metric type", http.StatusBadRequest)
 	}
-	http.Error(writer, "not implemented", http.StatusNotImplemented)
+
+	if replayed {
+		ms.log.S().Debugf("Replaying response to request %s", key)
+		writer.Header().Set(headerIdempotentReplayed, "true")
+	}
+	writer.WriteHeader(saved.StatusCode)
+	if _, err := writer.Write(saved.Body); err != nil {
+		ms.log.S().Error(err)
+	}
+}
+
+// isInvalidMetric reports whether the repository refused a metric rather
+// than failed to write it.
+func isInvalidMetric(err error) bool {
+	return errors.Is(err, repository.ErrorHistogramBucketsMismatch) ||
+		errors.Is(err, repository.ErrorHistogramIsNotValid) ||
+		errors.Is(err, repository.ErrorCounterIsNoValid) ||
+		errors.Is(err, repository.ErrorGaugeIsNotValid)
 }
 
 func (ms MetricsServer) GetMetric() http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		metricType := chi.URLParam(request, "metricType")
 		metricName := chi.URLParam(request, "metricName")
+		lbls, err := labelsFromQuery(request)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
 		switch metricType {
 		case "counter":
-			ms.writeCounter(writer, metricName)
+			ms.writeCounter(request.Context(), writer, metricName, lbls)
 		case "gauge":
-			ms.writeGauge(writer, metricName)
+			ms.writeGauge(request.Context(), writer, metricName, lbls)
+		case "histogram":
+			ms.writeHistogram(request.Context(), writer, metricName, lbls)
 		default:
 			http.Error(writer, "", http.StatusNotFound)
 		}
 	}
 }
 
-func (ms MetricsServer) writeGauge(writer http.ResponseWriter, gaugeName string) {
-	val, err := ms.db.RetrieveGauge(gaugeName)
+func (ms MetricsServer) writeGauge(ctx context.Context, writer http.ResponseWriter, gaugeName string, lbls labels.Labels) {
+	val, err := ms.db.RetrieveGauge(ctx, gaugeName, lbls)
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusNotFound)
 		return
@@ -221,8 +425,8 @@ func (ms MetricsServer) writeGauge(writer http.ResponseWriter, gaugeName string)
 	writer.WriteHeader(http.StatusOK)
 }
 
-func (ms MetricsServer) writeCounter(writer http.ResponseWriter, counterName string) {
-	val, err := ms.db.RetrieveCounter(counterName)
+func (ms MetricsServer) writeCounter(ctx context.Context, writer http.ResponseWriter, counterName string, lbls labels.Labels) {
+	val, err := ms.db.RetrieveCounter(ctx, counterName, lbls)
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusNotFound)
 		return
@@ -235,6 +439,31 @@ func (ms MetricsServer) writeCounter(writer http.ResponseWriter, counterName str
 	writer.WriteHeader(http.StatusOK)
 }
 
+// writeHistogram renders cumulative bucket counts followed by sum and count,
+// one value per line.
+func (ms MetricsServer) writeHistogram(ctx context.Context, writer http.ResponseWriter, histogramName string, lbls labels.Labels) {
+	val, err := ms.db.RetrieveHistogram(ctx, histogramName, lbls)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var sb strings.Builder
+	cumulative := val.Cumulative()
+	for i, b := range val.Buckets {
+		sb.WriteString(fmt.Sprintf("le=%s %d\n", strconv.FormatFloat(b, 'g', -1, 64), cumulative[i]))
+	}
+	sb.WriteString(fmt.Sprintf("le=+Inf %d\n", cumulative[len(cumulative)-1]))
+	sb.WriteString(fmt.Sprintf("sum %s\n", strconv.FormatFloat(val.Sum, 'g', -1, 64)))
+	sb.WriteString(fmt.Sprintf("count %d\n", val.Count))
+
+	_, err = io.WriteString(writer, sb.String())
+	if err != nil {
+		http.Error(writer, "internal error", http.StatusInternalServerError)
+		return
+	}
+}
+
 func (ms MetricsServer) UpdateGauge() http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		metricName := chi.URLParam(request, "gaugeName")
@@ -251,13 +480,20 @@ func (ms MetricsServer) UpdateGauge() http.HandlerFunc {
 			return
 		}
 
+		lbls, err := labelsFromQuery(request)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		metric := handlers.Metrics{
-			ID:    metricName,
-			MType: "gauge",
-			Delta: nil,
-			Value: &val,
+			ID:     metricName,
+			MType:  "gauge",
+			Delta:  nil,
+			Value:  &val,
+			Labels: lbls,
 		}
-		ms.saveMetric(writer, metric)
+		ms.saveMetric(request.Context(), writer, metric)
 	}
 }
 
@@ -272,13 +508,20 @@ func (ms MetricsServer) UpdateCounters() http.HandlerFunc {
 			return
 		}
 
+		lbls, err := labelsFromQuery(request)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		metric := handlers.Metrics{
-			ID:    metricName,
-			MType: "counter",
-			Delta: &val,
-			Value: nil,
+			ID:     metricName,
+			MType:  "counter",
+			Delta:  &val,
+			Value:  nil,
+			Labels: lbls,
 		}
-		ms.saveMetric(writer, metric)
+		ms.saveMetric(request.Context(), writer, metric)
 	}
 
 }
@@ -287,15 +530,30 @@ func (ms MetricsServer) ReturnCurrentMetrics() http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		var sb strings.Builder
 
-		gauges, counters, err := ms.db.ListStoredMetrics()
+		matchers, err := matchersFromQuery(request)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		gauges, counters, err := ms.db.ListStoredMetrics(request.Context(), matchers...)
 		if err != nil {
 			http.Error(writer, "internal error", http.StatusInternalServerError)
 		}
 		for _, g := range gauges {
-			sb.WriteString(fmt.Sprintf("%s\t\t%f\n", g.Name, g.Value))
+			sb.WriteString(fmt.Sprintf("%s\t\t%f\n", g.SeriesKey(), g.Value))
 		}
 		for _, c := range counters {
-			sb.WriteString(fmt.Sprintf("%s\t\t%d\n", c.Name, c.Value))
+			sb.WriteString(fmt.Sprintf("%s\t\t%d\n", c.SeriesKey(), c.Value))
+		}
+
+		histograms, err := ms.db.ListStoredHistograms(request.Context(), matchers...)
+		if err != nil {
+			http.Error(writer, "internal error", http.StatusInternalServerError)
+			return
+		}
+		for _, h := range histograms {
+			sb.WriteString(fmt.Sprintf("%s\t\tcount=%d sum=%f\n", h.SeriesKey(), h.Count, h.Sum))
 		}
 		writer.Header().Set("Content-Type", "text/html")
 		_, err = io.WriteString(writer, sb.String())
@@ -308,6 +566,30 @@ func (ms MetricsServer) ReturnCurrentMetrics() http.HandlerFunc {
 	}
 }
 
+// labelsFromQuery treats every query parameter of a single series request
+// except reserved as a label, e.g. /value/gauge/Alloc?host=web-1
+func labelsFromQuery(request *http.Request, reserved ...string) (labels.Labels, error) {
+	query := request.URL.Query()
+	for _, k := range reserved {
+		query.Del(k)
+	}
+	lbls := make(map[string]string, len(query))
+	for k := range query {
+		lbls[k] = query.Get(k)
+	}
+	l := labels.FromMap(lbls)
+	if err := l.Validate(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// matchersFromQuery reads repeated match parameters of list requests,
+// e.g. /metrics?match=host=web-1&match=env!~dev|test
+func matchersFromQuery(request *http.Request) ([]labels.Matcher, error) {
+	return labels.ParseMatchers(request.URL.Query()["match"])
+}
+
 func (ms MetricsServer) FlushToDisk() {
 	// TODO: Remove this redundant method
 }
@@ -342,7 +624,7 @@ func (ms MetricsServer) writeHash(h *handlers.Metrics) error {
 
 func (ms MetricsServer) Ping() http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		if ms.db.Ping() {
+		if ms.db.Ping(request.Context()) {
 			writer.WriteHeader(http.StatusOK)
 			return
 		}
@@ -363,36 +645,17 @@ func (ms MetricsServer) Updates() http.HandlerFunc {
 			return
 		}
 
-		for _, metric := range metricList {
-			ok, err := ms.hashIsValid(metric)
-			if !ok {
-				http.Error(writer, err.Error(), http.StatusBadRequest)
-				return
-			}
-		}
-
-		var gauges []metrics.Gauge
-		var counters []metrics.Counter
-
-		for _, m := range metricList {
-			switch m.MType {
-			case "counter":
-				counters = append(counters, metrics.CounterFromHandler(m))
-			case "gauge":
-				gauges = append(gauges, metrics.GaugeFromHandler(m))
-			}
-		}
-
-		err = ms.db.WriteBulkGauges(gauges)
-		if err != nil {
-			ms.log.S().Error("Bulk write to db failed: ", err)
-			http.Error(writer, "internal error", http.StatusInternalServerError)
+		if key := request.Header.Get(handlers.HeaderIdempotencyKey); key != "" {
+			ms.saveMetricsOnce(request.Context(), writer, key, metricList)
 			return
 		}
 
-		err = ms.db.WriteBulkCounters(counters)
-		if err != nil {
-			ms.log.S().Error("Bulk write to db failed: ", err)
+		err = ms.storeMetrics(request.Context(), metricList)
+		switch {
+		case errors.Is(err, errInvalidMetric):
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
 			http.Error(writer, "internal error", http.StatusInternalServerError)
 			return
 		}
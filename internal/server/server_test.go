@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -14,6 +15,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/OmAsana/yapraktikum/internal/handlers"
 	"github.com/OmAsana/yapraktikum/internal/metrics"
 	"github.com/OmAsana/yapraktikum/internal/repository"
 	"github.com/OmAsana/yapraktikum/internal/repository/inmemorystore"
@@ -172,7 +174,7 @@ func TestMetricsServer_UpdateCounters(t *testing.T) {
 
 				}
 				if test.wantStatus == http.StatusOK {
-					got, err := srv.db.RetrieveCounter(test.wantCouter.Name)
+					got, err := srv.db.RetrieveCounter(context.Background(), test.wantCouter.Name, nil)
 					if test.wantErr {
 						require.Error(t, err, err)
 					} else {
@@ -247,7 +249,7 @@ func TestMetricsServer_UpdateGauge(t *testing.T) {
 					require.Equal(t, test.wantStatus, resp.StatusCode, body)
 				}
 				if test.wantStatus == http.StatusOK {
-					got, err := srv.db.RetrieveGauge(test.wantGauge.Name)
+					got, err := srv.db.RetrieveGauge(context.Background(), test.wantGauge.Name, nil)
 					if test.wantErr {
 						require.Error(t, err, err)
 					} else {
@@ -510,3 +512,243 @@ func TestMetricsServer_Update(t *testing.T) {
 		})
 	}
 }
+
+func TestMetricsServer_Labels(t *testing.T) {
+	srv, err := NewMetricsServer(SetupRepo(t))
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	updates := `[
+{"id": "Alloc", "type": "gauge", "value": 1, "labels": {"host": "web-1", "env": "prod"}},
+{"id": "Alloc", "type": "gauge", "value": 2, "labels": {"host": "web-2", "env": "prod"}},
+{"id": "Alloc", "type": "gauge", "value": 3},
+{"id": "PollCount", "type": "counter", "delta": 5, "labels": {"host": "web-1"}},
+{"id": "PollCount", "type": "counter", "delta": 7, "labels": {"host": "web-2"}}
+]`
+	resp, body := executeTestRequest(t, ts, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", strings.NewReader(updates))
+		if err != nil {
+			return req, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, err
+	})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+
+	t.Run("get by labels", func(t *testing.T) {
+		tests := []struct {
+			uri  string
+			want string
+			code int
+		}{
+			{uri: "/value/gauge/Alloc?host=web-1&env=prod", want: "1", code: http.StatusOK},
+			{uri: "/value/gauge/Alloc?env=prod&host=web-2", want: "2", code: http.StatusOK},
+			{uri: "/value/gauge/Alloc", want: "3", code: http.StatusOK},
+			{uri: "/value/gauge/Alloc?host=web-1", code: http.StatusNotFound},
+			{uri: "/value/counter/PollCount?host=web-2", want: "7", code: http.StatusOK},
+		}
+		for _, tt := range tests {
+			t.Run(tt.uri, func(t *testing.T) {
+				resp, body := testRequest(t, ts, http.MethodGet, tt.uri, nil)
+				defer resp.Body.Close()
+				require.Equal(t, tt.code, resp.StatusCode, body)
+				if tt.code == http.StatusOK {
+					assert.Equal(t, tt.want, body)
+				}
+			})
+		}
+	})
+
+	t.Run("value json", func(t *testing.T) {
+		resp, body := executeTestRequest(t, ts, func() (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/value/",
+				strings.NewReader(`{"id": "PollCount", "type": "counter", "labels": {"host": "web-1"}}`))
+			if err != nil {
+				return req, err
+			}
+			req.Header.Set("Accept", "application/json")
+			return req, err
+		})
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+		assert.JSONEq(t, `{"id": "PollCount", "type": "counter", "delta": 5, "labels": {"host": "web-1"}}`, body)
+	})
+
+	t.Run("list with matchers", func(t *testing.T) {
+		resp, body := testRequest(t, ts, http.MethodGet, "/metrics?match=host=~web-.*&match=env!=dev", nil)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+		want := `# TYPE Alloc gauge
+Alloc{env="prod",host="web-1"} 1
+Alloc{env="prod",host="web-2"} 2
+# TYPE PollCount counter
+PollCount{host="web-1"} 5
+PollCount{host="web-2"} 7
+`
+		assert.Equal(t, want, body)
+	})
+
+	t.Run("invalid matcher", func(t *testing.T) {
+		resp, body := testRequest(t, ts, http.MethodGet, "/?match=host", nil)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+	})
+}
+
+func TestMetricsServer_Histogram(t *testing.T) {
+	srv, err := NewMetricsServer(SetupRepo(t))
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	post := func(uri, body string) (*http.Response, string) {
+		return executeTestRequest(t, ts, func() (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+uri, strings.NewReader(body))
+			if err != nil {
+				return req, err
+			}
+			req.Header.Set("Content-Type", "application/json")
+			return req, err
+		})
+	}
+
+	resp, body := post("/update/", `{"id": "latency", "type": "histogram", "buckets": [0.1, 1], "counts": [1, 2, 0], "sum": 1.05, "count": 3}`)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+
+	resp, body = post("/updates/", `[{"id": "latency", "type": "histogram", "buckets": [0.1, 1], "counts": [0, 0, 1], "sum": 2, "count": 1}]`)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+
+	t.Run("buckets mismatch", func(t *testing.T) {
+		resp, body := post("/update/", `{"id": "latency", "type": "histogram", "buckets": [1], "counts": [1, 0], "sum": 1, "count": 1}`)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+	})
+
+	t.Run("failed batch stores nothing", func(t *testing.T) {
+		resp, body := post("/updates/", `[{"id": "BatchGauge", "type": "gauge", "value": 1},
+{"id": "BatchCounter", "type": "counter", "delta": 1},
+{"id": "latency", "type": "histogram", "buckets": [1], "counts": [1, 0], "sum": 1, "count": 1}]`)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+
+		resp, _ = testRequest(t, ts, http.MethodGet, "/value/gauge/BatchGauge", nil)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		resp, _ = testRequest(t, ts, http.MethodGet, "/value/counter/BatchCounter", nil)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("invalid counts", func(t *testing.T) {
+		resp, body := post("/update/", `{"id": "other", "type": "histogram", "buckets": [1], "counts": [1], "sum": 1, "count": 1}`)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+	})
+
+	t.Run("value", func(t *testing.T) {
+		resp, body := testRequest(t, ts, http.MethodGet, "/value/histogram/latency", nil)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+		assert.Equal(t, "le=0.1 1\nle=1 3\nle=+Inf 4\nsum 3.05\ncount 4\n", body)
+	})
+
+	t.Run("prometheus", func(t *testing.T) {
+		resp, body := testRequest(t, ts, http.MethodGet, "/metrics", nil)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+		want := `# TYPE latency histogram
+latency_bucket{le="0.1"} 1
+latency_bucket{le="1"} 3
+latency_bucket{le="+Inf"} 4
+latency_sum 3.05
+latency_count 4
+`
+		assert.Equal(t, want, body)
+	})
+}
+
+func TestMetricsServer_IdempotencyKey(t *testing.T) {
+	srv, err := NewMetricsServer(SetupRepo(t))
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	post := func(uri, key, body string) (*http.Response, string) {
+		return executeTestRequest(t, ts, func() (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+uri, strings.NewReader(body))
+			if err != nil {
+				return req, err
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(handlers.HeaderIdempotencyKey, key)
+			return req, err
+		})
+	}
+	counterValue := func(t *testing.T, name string) string {
+		resp, body := testRequest(t, ts, http.MethodGet, "/value/counter/"+name, nil)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+		return body
+	}
+
+	tests := []struct {
+		name string
+		uri  string
+		id   string
+		body string
+	}{
+		{name: "updates", uri: "/updates/", id: "Batch", body: `[{"id": "Batch", "type": "counter", "delta": 2}]`},
+		{name: "update", uri: "/update/", id: "Single", body: `{"id": "Single", "type": "counter", "delta": 2}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := post(tt.uri, tt.name+"-1", tt.body)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode, body)
+			assert.Empty(t, resp.Header.Get(headerIdempotentReplayed))
+
+			resp, body = post(tt.uri, tt.name+"-1", tt.body)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode, body)
+			assert.Equal(t, "true", resp.Header.Get(headerIdempotentReplayed))
+			assert.Equal(t, "2", counterValue(t, tt.id))
+
+			resp, body = post(tt.uri, tt.name+"-2", tt.body)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode, body)
+			assert.Equal(t, "4", counterValue(t, tt.id))
+		})
+	}
+
+	t.Run("key reused with another batch", func(t *testing.T) {
+		resp, body := post("/updates/", "reused", `[{"id": "Reused", "type": "counter", "delta": 1}]`)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+
+		resp, body = post("/updates/", "reused", `[{"id": "Reused", "type": "counter", "delta": 5}]`)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode, body)
+		assert.Equal(t, "1", counterValue(t, "Reused"))
+	})
+
+	t.Run("invalid batch is not remembered", func(t *testing.T) {
+		resp, body := post("/updates/", "invalid", `[{"id": "Fixed", "type": "counter", "delta": -1}]`)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+
+		resp, body = post("/updates/", "invalid", `[{"id": "Fixed", "type": "counter", "delta": 1}]`)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+		assert.Equal(t, "1", counterValue(t, "Fixed"))
+	})
+
+	t.Run("key too long", func(t *testing.T) {
+		resp, body := post("/updates/", strings.Repeat("k", maxIdempotencyKeyLength+1), `[{"id": "Batch", "type": "counter", "delta": 2}]`)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+	})
+}